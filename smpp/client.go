@@ -5,15 +5,20 @@
 package smpp
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"io"
 	"math"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fiorix/go-smpp/smpp/pdu"
 	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
 )
 
 // ConnStatus is an abstract interface for a connection status change.
@@ -80,18 +85,51 @@ type RateLimiter interface {
 	Wait(ctx context.Context) error
 }
 
+// CongestionLimiter is an optional interface of RateLimiter, for
+// limiters that adapt to the congestion_state reported by the SMSC
+// in enquire_link_resp, from 0 (idle) to 100 (congested).
+type CongestionLimiter interface {
+	RateLimiter
+
+	// SetCongestionState is called with every congestion_state
+	// received from the SMSC.
+	SetCongestionState(state uint8)
+}
+
+// minEnquireLink is the minimum, and default, EnquireLink interval.
+var minEnquireLink = 10 * time.Second
+
 // client provides a persistent client connection.
 type client struct {
-	Addr               string
-	TLS                *tls.Config
-	Status             chan ConnStatus
-	BindFunc           func(c Conn) error
-	EnquireLink        time.Duration
-	EnquireLinkTimeout time.Duration
-	RespTimeout        time.Duration
-	BindInterval       time.Duration
-	WindowSize         uint
-	RateLimiter        RateLimiter
+	Addr                 string
+	Network              string
+	TLS                  *tls.Config
+	Status               chan ConnStatus
+	BindFunc             func(c Conn) error
+	EnquireLink          time.Duration
+	EnquireLinkTimeout   time.Duration
+	CoalesceEnquireLink  bool
+	AnyPDUKeepAlive      bool
+	RespTimeout          time.Duration
+	RetryOnReconnect     time.Duration
+	DialTimeout          time.Duration
+	LocalAddr            net.Addr
+	BindTimeout          time.Duration
+	WriteTimeout         time.Duration
+	BindInterval         time.Duration
+	AlreadyBoundInterval time.Duration
+	BindLimit            *BindLimit
+	TrackUserRef         bool
+	DefaultTLVs          pdufield.TLVMap
+	OnBeforeSend         func(seq uint32, p pdu.Body)
+	MessageIDNormalizer  func(id string) string
+	OrderByDst           bool
+	ShortMessageFilter   func(b []byte) []byte
+	DataCodings          []pdutext.DataCoding
+	SMLengthPolicy       *pdufield.SMLengthPolicy
+	WindowSize           uint
+	RateLimiter          RateLimiter
+	BindTracer           func(bt *BindTrace)
 
 	// internal stuff.
 	inbox chan pdu.Body
@@ -102,17 +140,34 @@ type client struct {
 	// time of the last received EnquireLinkResp
 	eliTime time.Time
 	eliMtx  sync.RWMutex
+	// time of the last outbound PDU
+	wrTime time.Time
+	wrMtx  sync.RWMutex
+	// congestion_state of the last EnquireLinkResp, -1 if unknown
+	congestion int32
+	// EnquireLink sent by Ping, waiting for the response
+	pings   map[uint32]chan struct{}
+	pingMtx sync.Mutex
+	// number of successful binds and whether the current session is
+	// bound, bindEvent is closed and replaced on every change
+	binds     uint64
+	bound     bool
+	bindEvent chan struct{}
+	bindMtx   sync.Mutex
 }
 
 func (c *client) init() {
 	c.inbox = make(chan pdu.Body)
 	c.conn = &connSwitch{}
 	c.stop = make(chan struct{})
+	c.congestion = -1
+	c.pings = make(map[uint32]chan struct{})
+	c.bindEvent = make(chan struct{})
 	if c.RateLimiter != nil {
 		c.lmctx = context.Background()
 	}
-	if c.EnquireLink < 10*time.Second {
-		c.EnquireLink = 10 * time.Second
+	if c.EnquireLink < minEnquireLink {
+		c.EnquireLink = minEnquireLink
 	}
 
 	if c.EnquireLinkTimeout == 0 {
@@ -127,20 +182,52 @@ func (c *client) Bind() {
 	const maxdelay = 120.0
 	for !c.closed() {
 		eli := make(chan struct{})
-		conn, err := Dial(c.Addr, c.TLS)
+		alreadyBound := false
+		slot := false
+		var conn *conn
+		var err error
+		if c.BindLimit != nil {
+			if slot = c.BindLimit.acquire(); !slot {
+				c.notify(&connStatus{
+					s:   ConnectionFailed,
+					err: ErrMaxBinds,
+				})
+				goto retry
+			}
+		}
+		conn, err = dial(&net.Dialer{Timeout: c.DialTimeout, LocalAddr: c.LocalAddr}, c.Network, c.Addr, c.TLS)
 		if err != nil {
+			if isTimeout(err) {
+				err = ErrDialTimeout
+			}
 			c.notify(&connStatus{
 				s:   ConnectionFailed,
 				err: err,
 			})
 			goto retry
 		}
+		conn.writeTimeout = c.WriteTimeout
+		conn.smLength = c.SMLengthPolicy
 		c.conn.Set(conn)
-		if err = c.BindFunc(c.conn); err != nil {
+		if c.BindTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(c.BindTimeout))
+		}
+		err = c.BindFunc(c.conn)
+		if c.BindTimeout > 0 {
+			conn.SetDeadline(time.Time{})
+		}
+		if err != nil {
+			if isTimeout(err) {
+				err = ErrBindTimeout
+			} else if err == statusAlreadyBound {
+				err = ErrAlreadyBound
+				alreadyBound = true
+			}
 			c.notify(&connStatus{s: BindFailed, err: err})
 			goto retry
 		}
 		go c.enquireLink(eli)
+		c.setBound(true)
 		c.notify(&connStatus{s: Connected})
 		delay = 1
 		for {
@@ -152,6 +239,15 @@ func (c *client) Bind() {
 				})
 				break
 			}
+			if c.AnyPDUKeepAlive {
+				// Some SMSCs ignore EnquireLink, traffic is
+				// the only evidence the link is alive.
+				c.updateEliTime()
+			} else if c.CoalesceEnquireLink && isResp(p) {
+				// EnquireLink is postponed while requests
+				// are sent, their responses stand for it.
+				c.updateEliTime()
+			}
 			switch p.Header().ID {
 			case pdu.EnquireLinkID:
 				pResp := pdu.NewEnquireLinkRespSeq(p.Header().Seq)
@@ -161,15 +257,31 @@ func (c *client) Bind() {
 				}
 			case pdu.EnquireLinkRespID:
 				c.updateEliTime()
+				c.updateCongestion(p)
+				c.pong(p.Header().Seq)
 			default:
+				if c.ShortMessageFilter != nil && acceptDataCoding(p, c.DataCodings) {
+					c.filterShortMessage(p)
+				}
+				if c.MessageIDNormalizer != nil {
+					c.normalizeMessageID(p)
+				}
 				c.inbox <- p
 			}
 		}
 	retry:
 		close(eli)
+		c.setBound(false)
 		c.conn.Close()
+		if slot {
+			c.BindLimit.release()
+		}
+		atomic.StoreInt32(&c.congestion, -1)
 		delayDuration := c.BindInterval
-		if delayDuration == 0 {
+		if alreadyBound && c.AlreadyBoundInterval > 0 {
+			// Give the SMSC time to expire the previous session.
+			delayDuration = c.AlreadyBoundInterval
+		} else if delayDuration == 0 {
 			delay = math.Min(delay*math.E, maxdelay)
 			delayDuration = time.Duration(delay) * time.Second
 		}
@@ -178,12 +290,49 @@ func (c *client) Bind() {
 	close(c.Status)
 }
 
+// BindLimit caps the number of concurrent sessions of the clients
+// sharing it, e.g. to the number of binds the SMSC allows per account.
+// Only the clients given the same BindLimit count against each other,
+// whatever their Addr or User.
+type BindLimit struct {
+	max int
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewBindLimit returns a BindLimit of max concurrent sessions.
+func NewBindLimit(max int) *BindLimit {
+	return &BindLimit{max: max}
+}
+
+// acquire reserves a session, unless max sessions are already open.
+func (l *BindLimit) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n >= l.max {
+		return false
+	}
+	l.n++
+	return true
+}
+
+// release releases a session reserved by acquire.
+func (l *BindLimit) release() {
+	l.mu.Lock()
+	l.n--
+	l.mu.Unlock()
+}
+
 func (c *client) enquireLink(stop chan struct{}) {
 	// for the first check set time as Now()
 	c.updateEliTime()
+	c.updateWrTime()
+	wait := c.EnquireLink
 	for {
 		select {
-		case <-time.After(c.EnquireLink):
+		case <-time.After(wait):
+			wait = c.EnquireLink
 			// check the time of the last received EnquireLinkResp
 			c.eliMtx.RLock()
 			if time.Since(c.eliTime) >= c.EnquireLinkTimeout {
@@ -193,6 +342,17 @@ func (c *client) enquireLink(stop chan struct{}) {
 				return
 			}
 			c.eliMtx.RUnlock()
+			if c.CoalesceEnquireLink {
+				idle := c.sinceWrTime()
+				if idle < c.EnquireLink {
+					// Outbound traffic is flowing, postpone the
+					// EnquireLink until the link is idle. The
+					// responses to that traffic keep it alive
+					// meanwhile, see the read loop in Bind.
+					wait = c.EnquireLink - idle
+					continue
+				}
+			}
 			// send the EnquireLink
 			err := c.conn.Write(pdu.NewEnquireLink())
 			if err != nil {
@@ -212,6 +372,194 @@ func (c *client) updateEliTime() {
 	c.eliMtx.Unlock()
 }
 
+func (c *client) updateWrTime() {
+	c.wrMtx.Lock()
+	c.wrTime = time.Now()
+	c.wrMtx.Unlock()
+}
+
+func (c *client) sinceWrTime() time.Duration {
+	c.wrMtx.RLock()
+	defer c.wrMtx.RUnlock()
+	return time.Since(c.wrTime)
+}
+
+// setBound records whether the current session is bound.
+func (c *client) setBound(bound bool) {
+	c.bindMtx.Lock()
+	defer c.bindMtx.Unlock()
+	if bound {
+		c.binds++
+	} else if !c.bound {
+		return
+	}
+	c.bound = bound
+	close(c.bindEvent)
+	c.bindEvent = make(chan struct{})
+}
+
+// bindState returns the number of successful binds so far, and
+// whether the current session is bound.
+func (c *client) bindState() (uint64, bool) {
+	c.bindMtx.Lock()
+	defer c.bindMtx.Unlock()
+	return c.binds, c.bound
+}
+
+// waitBind waits up to timeout for a session bound after the n-th
+// bind.
+func (c *client) waitBind(n uint64, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		c.bindMtx.Lock()
+		ok := c.binds > n && c.bound
+		ev := c.bindEvent
+		c.bindMtx.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-ev:
+		case <-timer.C:
+			return ErrNotConnected
+		case <-c.stop:
+			return ErrNotConnected
+		}
+	}
+}
+
+// Ping sends an EnquireLink and returns the time it took for its
+// response to arrive, or ctx to be done.
+func (c *client) Ping(ctx context.Context) (time.Duration, error) {
+	p := pdu.NewEnquireLink()
+	seq := p.Header().Seq
+	done := make(chan struct{}, 1)
+	c.pingMtx.Lock()
+	c.pings[seq] = done
+	c.pingMtx.Unlock()
+	defer func() {
+		c.pingMtx.Lock()
+		delete(c.pings, seq)
+		c.pingMtx.Unlock()
+	}()
+	start := time.Now()
+	if err := c.conn.Write(p); err != nil {
+		return 0, err
+	}
+	select {
+	case <-done:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.stop:
+		return 0, ErrNotConnected
+	}
+}
+
+// pong notifies Ping of the EnquireLinkResp with the given sequence
+// number, if it is waiting for it.
+func (c *client) pong(seq uint32) {
+	c.pingMtx.Lock()
+	defer c.pingMtx.Unlock()
+	if done, ok := c.pings[seq]; ok {
+		select {
+		case done <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// acceptDataCoding reports whether the data_coding of p, if any, is
+// one of dcs. Any is accepted if dcs is empty.
+func acceptDataCoding(p pdu.Body, dcs []pdutext.DataCoding) bool {
+	f, ok := p.Fields()[pdufield.DataCoding]
+	if !ok || len(dcs) == 0 {
+		return true
+	}
+	dc := pdutext.DataCoding(f.Bytes()[0])
+	for _, v := range dcs {
+		if v == dc {
+			return true
+		}
+	}
+	return false
+}
+
+// filterShortMessage rewrites the short_message of p with the
+// ShortMessageFilter. The original is still available with
+// OriginalShortMessage.
+func (c *client) filterShortMessage(p pdu.Body) {
+	f := p.Fields()
+	sm, ok := f[pdufield.ShortMessage]
+	if !ok {
+		return
+	}
+	f.Set(pdufield.ShortMessage, c.ShortMessageFilter(append([]byte{}, sm.Bytes()...)))
+}
+
+// normalizeMessageID rewrites the message_id of p, a response, or the
+// receipted_message_id and delivery receipt id of p, a deliver_sm or
+// data_sm, with the MessageIDNormalizer. The text of p is left alone
+// unless esm_class marks p as a receipt.
+func (c *client) normalizeMessageID(p pdu.Body) {
+	f := p.Fields()
+	switch p.Header().ID {
+	case pdu.SubmitSMRespID, pdu.SubmitMultiRespID, pdu.DataSMRespID:
+		if id, ok := f[pdufield.MessageID]; ok {
+			f.Set(pdufield.MessageID, c.MessageIDNormalizer(id.String()))
+		}
+	case pdu.DeliverSMID, pdu.DataSMID:
+		tlv := p.TLVFields()
+		if id, ok := tlv[pdufield.ReceiptedMessageID]; ok {
+			// C-Octet String, keep the terminator if present.
+			v := string(id.Bytes())
+			nul := strings.HasSuffix(v, "\x00")
+			v = c.MessageIDNormalizer(strings.TrimRight(v, "\x00"))
+			if nul {
+				v += "\x00"
+			}
+			tlv.Set(pdufield.ReceiptedMessageID, v)
+		}
+		if !isReceipt(p) { // Subscriber text, e.g. "id:ABC is my code"
+			return
+		}
+		if sm, ok := f[pdufield.ShortMessage]; ok && sm.Len() > 0 {
+			if text, ok := replaceReceiptID(sm.String(), c.MessageIDNormalizer); ok {
+				f.Set(pdufield.ShortMessage, []byte(text))
+			}
+		} else if mp, ok := tlv[pdufield.MessagePayload]; ok {
+			if text, ok := replaceReceiptID(string(mp.Bytes()), c.MessageIDNormalizer); ok {
+				tlv.Set(pdufield.MessagePayload, text)
+			}
+		}
+	}
+}
+
+// updateCongestion records the congestion_state TLV of p, if present,
+// and passes it on to the RateLimiter if it is a CongestionLimiter.
+func (c *client) updateCongestion(p pdu.Body) {
+	tlv, ok := p.TLVFields()[pdufield.CongestionState]
+	if !ok || len(tlv.Bytes()) != 1 {
+		return
+	}
+	state := tlv.Bytes()[0]
+	atomic.StoreInt32(&c.congestion, int32(state))
+	if cl, ok := c.RateLimiter.(CongestionLimiter); ok {
+		cl.SetCongestionState(state)
+	}
+}
+
+// congestionState returns the last congestion_state received, and
+// whether one was received on the current connection.
+func (c *client) congestionState() (uint8, bool) {
+	v := atomic.LoadInt32(&c.congestion)
+	if v < 0 {
+		return 0, false
+	}
+	return uint8(v), true
+}
+
 func (c *client) notify(ev ConnStatus) {
 	select {
 	case c.Status <- ev:
@@ -234,6 +582,9 @@ func (c *client) Write(w pdu.Body) error {
 	if c.RateLimiter != nil {
 		c.RateLimiter.Wait(c.lmctx)
 	}
+	if c.CoalesceEnquireLink {
+		c.updateWrTime()
+	}
 	return c.conn.Write(w)
 }
 
@@ -279,20 +630,78 @@ func (c *client) respTimeout() <-chan time.Time {
 	return time.After(c.RespTimeout)
 }
 
-// bind attempts to bind the connection.
-func bind(c Conn, p pdu.Body) (pdu.Body, error) {
+// BindTrace contains the details of a bind handshake, for
+// troubleshooting integrations that fail to bind.
+type BindTrace struct {
+	SystemID         string
+	SystemType       string
+	InterfaceVersion uint8
+	Req              []byte     // Bind PDU as sent, with the password redacted.
+	Resp             []byte     // Bind response PDU as received, re-encoded.
+	Status           pdu.Status // Status of the bind response.
+	Err              error      // Error of the handshake, if any.
+}
+
+// setReq records the given bind PDU, redacting its password.
+func (bt *BindTrace) setReq(p pdu.Body) {
+	f := p.Fields()
+	if v := f[pdufield.SystemID]; v != nil {
+		bt.SystemID = v.String()
+	}
+	if v := f[pdufield.SystemType]; v != nil {
+		bt.SystemType = v.String()
+	}
+	if v := f[pdufield.InterfaceVersion]; v != nil {
+		bt.InterfaceVersion, _ = v.Raw().(uint8)
+	}
+	passwd := f[pdufield.Password]
+	if passwd != nil {
+		f.Set(pdufield.Password, strings.Repeat("*", len(passwd.String())))
+	}
+	var b bytes.Buffer
+	if err := p.SerializeTo(&b); err == nil {
+		bt.Req = b.Bytes()
+	}
+	if passwd != nil {
+		f[pdufield.Password] = passwd
+	}
+}
+
+// setResp records the given bind response PDU.
+func (bt *BindTrace) setResp(p pdu.Body) {
+	bt.Status = p.Header().Status
+	var b bytes.Buffer
+	if err := p.SerializeTo(&b); err == nil {
+		bt.Resp = b.Bytes()
+	}
+}
+
+// bind attempts to bind the connection. If trace is set, it is
+// called with the details of the handshake before returning.
+func bind(c Conn, p pdu.Body, trace func(bt *BindTrace)) (pdu.Body, error) {
 	f := p.Fields()
 	f.Set(pdufield.InterfaceVersion, 0x34)
+	var bt BindTrace
+	if trace != nil {
+		bt.setReq(p)
+		defer func() { trace(&bt) }()
+	}
 	err := c.Write(p)
 	if err != nil {
+		bt.Err = err
 		return nil, err
 	}
 	resp, err := c.Read()
 	if err != nil {
+		bt.Err = err
 		return nil, err
 	}
+	if trace != nil {
+		bt.setResp(resp)
+	}
 	h := resp.Header()
 	if h.Status != 0 {
+		bt.Err = h.Status
 		return nil, h.Status
 	}
 	return resp, nil
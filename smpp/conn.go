@@ -12,8 +12,11 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
 )
 
 var (
@@ -23,6 +26,34 @@ var (
 	// ErrNotBound is returned on attempts to use a Transmitter,
 	// Receiver or Transceiver before calling Bind.
 	ErrNotBound = errors.New("not bound")
+
+	// ErrDialTimeout is returned when connecting to the server
+	// takes longer than the configured DialTimeout.
+	ErrDialTimeout = errors.New("timeout dialing to server")
+
+	// ErrBindTimeout is returned when the bind response does not
+	// arrive within the configured BindTimeout.
+	ErrBindTimeout = errors.New("timeout waiting for bind response")
+
+	// ErrAlreadyBound is returned when bind fails with ESME_RALYBND,
+	// usually because the SMSC still considers a previous session
+	// alive, e.g. after an ungraceful disconnect.
+	ErrAlreadyBound = errors.New("already bound, previous session still alive")
+
+	// ErrWriteTimeout is returned when writing a PDU takes longer
+	// than the configured WriteTimeout, e.g. because the server
+	// stopped reading. The connection is closed.
+	ErrWriteTimeout = errors.New("timeout writing to server")
+
+	// ErrMaxBinds is returned when a client does not bind because
+	// its BindLimit is reached by the clients sharing it.
+	ErrMaxBinds = errors.New("maximum number of binds to server reached")
+)
+
+// Command status values used by clients.
+const (
+	statusAlreadyBound         pdu.Status = 0x00000005 // ESME_RALYBND
+	statusReceiverTempAppError pdu.Status = 0x00000064 // ESME_RX_T_APPN
 )
 
 // Conn is an SMPP connection.
@@ -53,10 +84,24 @@ type Closer interface {
 // Dial dials to the SMPP server and returns a Conn, or error.
 // TLS is only used if provided.
 func Dial(addr string, TLS *tls.Config) (Conn, error) {
-	if addr == "" {
+	return dial(&net.Dialer{}, "tcp", addr, TLS)
+}
+
+// dialConn opens the network connection, replaced in tests.
+var dialConn = func(d *net.Dialer, network, addr string) (net.Conn, error) {
+	return d.Dial(network, addr)
+}
+
+// dial dials to the SMPP server using the given dialer, over the
+// given network, tcp if empty.
+func dial(d *net.Dialer, network, addr string, TLS *tls.Config) (*conn, error) {
+	if network == "" {
+		network = "tcp"
+	}
+	if addr == "" && network == "tcp" {
 		addr = "localhost:2775"
 	}
-	fd, err := net.Dial("tcp", addr)
+	fd, err := dialConn(d, network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -77,11 +122,19 @@ type conn struct {
 	rwc net.Conn
 	r   *bufio.Reader
 	w   *bufio.Writer
+
+	// writeTimeout, if set, closes the connection when a Write
+	// does not complete in time.
+	writeTimeout time.Duration
+
+	// smLength, if set, is how to decode PDUs with a sm_length
+	// that disagrees with the short message.
+	smLength *pdufield.SMLengthPolicy
 }
 
 // Read implements the Conn interface.
 func (c *conn) Read() (pdu.Body, error) {
-	return pdu.Decode(c.r)
+	return pdu.DecodeWith(c.r, c.smLength)
 }
 
 // Write implements the Conn interface.
@@ -91,7 +144,25 @@ func (c *conn) Write(w pdu.Body) error {
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(c.w, &b)
+	if c.writeTimeout > 0 {
+		// Watchdog for writes blocked on a peer that stopped reading.
+		// Deadlines are not enough, not every net.Conn honors them.
+		var timedOut int32
+		wd := time.AfterFunc(c.writeTimeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			c.rwc.Close()
+		})
+		err = c.write(&b)
+		if !wd.Stop() && atomic.LoadInt32(&timedOut) == 1 {
+			return ErrWriteTimeout
+		}
+		return err
+	}
+	return c.write(&b)
+}
+
+func (c *conn) write(b *bytes.Buffer) error {
+	_, err := io.Copy(c.w, b)
 	if err != nil {
 		return err
 	}
@@ -103,6 +174,30 @@ func (c *conn) Close() error {
 	return c.rwc.Close()
 }
 
+// SetDeadline sets the read and write deadlines of the connection.
+// A zero value for t means Read and Write will not time out.
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.rwc.SetDeadline(t)
+}
+
+// isTimeout returns true if err is a network timeout.
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// isConnErr returns true if err means the connection is gone, and
+// writing again once rebound is safe. Not so after ErrWriteTimeout,
+// the server may still get the PDU, nor for errors encoding it.
+func isConnErr(err error) bool {
+	switch err {
+	case ErrNotConnected, ErrNotBound:
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && !ne.Timeout()
+}
+
 // connSwitch implements the Conn interface but allows switching
 // the actual Conn object it wraps.
 //
@@ -0,0 +1,113 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"errors"
+
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+)
+
+// ErrEMSTooLong is returned by EMS.ShortMessage when the user data
+// header and text do not fit in a single short message.
+var ErrEMSTooLong = errors.New("ems too long for a single short message")
+
+// EMSStyle is the format mode of the EMS text formatting element.
+type EMSStyle uint8
+
+// Supported EMS text styles, may be combined.
+const (
+	EMSBold          EMSStyle = 0x10
+	EMSItalic        EMSStyle = 0x20
+	EMSUnderline     EMSStyle = 0x40
+	EMSStrikethrough EMSStyle = 0x80
+)
+
+// EMS information element identifiers, see 3GPP TS 23.040.
+const (
+	emsTextFormatting      = 0x0A
+	emsPredefinedSound     = 0x0B
+	emsPredefinedAnimation = 0x0D
+)
+
+// maxUserData is the maximum length in octets of the user data of a
+// single short message, including the user data header.
+const maxUserData = 140
+
+// EMS builds an Enhanced Messaging Service short message, the text
+// and the information elements that format it or position sounds
+// and animations in it. Positions and lengths are in characters.
+type EMS struct {
+	text pdutext.Codec
+	ies  []byte
+}
+
+// NewEMS returns a new EMS builder for the given text.
+func NewEMS(text pdutext.Codec) *EMS {
+	return &EMS{text: text}
+}
+
+// Format adds a text formatting element, styling length characters
+// of the text from start.
+func (e *EMS) Format(start, length int, style EMSStyle) *EMS {
+	e.ies = append(e.ies, emsTextFormatting, 3, uint8(start), uint8(length), uint8(style))
+	return e
+}
+
+// Sound adds a predefined sound, played at position pos of the text.
+func (e *EMS) Sound(pos int, sound uint8) *EMS {
+	e.ies = append(e.ies, emsPredefinedSound, 2, uint8(pos), sound)
+	return e
+}
+
+// Animation adds a predefined animation, shown at position pos of
+// the text.
+func (e *EMS) Animation(pos int, animation uint8) *EMS {
+	e.ies = append(e.ies, emsPredefinedAnimation, 2, uint8(pos), animation)
+	return e
+}
+
+// ShortMessage returns a ShortMessage carrying the EMS, with the UDHI
+// flag set in esm_class. Other fields of the ShortMessage may be set
+// before submitting it.
+func (e *EMS) ShortMessage(src, dst string) (*ShortMessage, error) {
+	text := &udhText{udh: e.udh(), text: e.text}
+	if pdutext.EncodedLen(e.text) > userDataLen(e.text, len(text.udh)) {
+		return nil, ErrEMSTooLong
+	}
+	return &ShortMessage{
+		Src:      src,
+		Dst:      dst,
+		Text:     text,
+		ESMClass: 0x40,
+	}, nil
+}
+
+// udh returns the user data header, including its length.
+func (e *EMS) udh() []byte {
+	return append([]byte{uint8(len(e.ies))}, e.ies...)
+}
+
+// udhText is a text codec that prepends a user data header to the
+// encoded text.
+type udhText struct {
+	udh  []byte
+	text pdutext.Codec
+}
+
+// Type implements the pdutext.Codec interface.
+func (t *udhText) Type() pdutext.DataCoding {
+	return t.text.Type()
+}
+
+// Encode implements the pdutext.Codec interface.
+func (t *udhText) Encode() []byte {
+	return append(append([]byte{}, t.udh...), t.text.Encode()...)
+}
+
+// Decode implements the pdutext.Codec interface.
+func (t *udhText) Decode() []byte {
+	return t.text.Decode()
+}
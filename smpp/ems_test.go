@@ -0,0 +1,45 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+)
+
+func TestEMS(t *testing.T) {
+	sm, err := NewEMS(pdutext.Raw("Hello world")).
+		Format(0, 5, EMSBold).
+		Sound(11, 3).
+		ShortMessage("root", "foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.ESMClass != 0x40 {
+		t.Fatalf("unexpected esm_class: want 0x40, have %#x", sm.ESMClass)
+	}
+	want := []byte("\x09\x0a\x03\x00\x05\x10\x0b\x02\x0b\x03Hello world")
+	if have := sm.Text.Encode(); !bytes.Equal(want, have) {
+		t.Fatalf("unexpected user data: want %q, have %q", want, have)
+	}
+	sm, err = NewEMS(pdutext.UCS2("Olá")).Format(0, 3, EMSItalic|EMSUnderline).ShortMessage("root", "foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Text.Type() != pdutext.UCS2Type {
+		t.Fatalf("unexpected data coding: want %#x, have %#x", pdutext.UCS2Type, sm.Text.Type())
+	}
+	want = []byte("\x05\x0a\x03\x00\x03\x60\x00O\x00l\x00\xe1")
+	if have := sm.Text.Encode(); !bytes.Equal(want, have) {
+		t.Fatalf("unexpected user data: want %q, have %q", want, have)
+	}
+	_, err = NewEMS(pdutext.Raw(strings.Repeat("x", 135))).Format(0, 1, EMSBold).ShortMessage("root", "foobar")
+	if err != ErrEMSTooLong {
+		t.Fatalf("unexpected error: want ErrEMSTooLong, have %v", err)
+	}
+}
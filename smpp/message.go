@@ -0,0 +1,186 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+)
+
+// NewSecureMessage returns a ShortMessage for secure messaging, with
+// the given privacy_indicator and protocol_id, and requesting the
+// handset to alert the user on delivery. Other fields of the
+// ShortMessage may be set before submitting it.
+func NewSecureMessage(src, dst string, text pdutext.Codec, privacy pdufield.Privacy, protocolID uint8) *ShortMessage {
+	sm := &ShortMessage{
+		Src:        src,
+		Dst:        dst,
+		Text:       text,
+		ProtocolID: protocolID,
+		TLVFields:  make(pdufield.TLVMap),
+	}
+	sm.TLVFields.SetPrivacyIndicator(privacy)
+	sm.TLVFields.SetAlertOnMessageDelivery()
+	return sm
+}
+
+// ErrNoFlashClass is returned by NewOTPMessage for text codecs with no
+// flash (class 0) data_coding.
+var ErrNoFlashClass = errors.New("otp message: no flash data_coding for text codec")
+
+// flashDataCoding maps text codecs to the GSM 03.38 data_coding of the
+// same alphabet with message class 0, shown by handsets immediately.
+var flashDataCoding = map[pdutext.DataCoding]pdutext.DataCoding{
+	pdutext.DefaultType: 0x10,
+	pdutext.UCS2Type:    0x18,
+}
+
+// NewOTPMessage returns a ShortMessage for one-time passwords, shown
+// immediately as a flash message, sent with priority and expiring after
+// validity. It replaces any message still pending at the SMSC with the
+// same source, destination and service_type, such as a previous OTP.
+// Only DefaultType and UCS2Type text can be flashed. Other fields of the
+// ShortMessage may be set before submitting it.
+func NewOTPMessage(src, dst string, text pdutext.Codec, validity time.Duration) (*ShortMessage, error) {
+	coding, ok := flashDataCoding[text.Type()]
+	if !ok {
+		return nil, ErrNoFlashClass
+	}
+	sm := &ShortMessage{
+		Src:                  src,
+		Dst:                  dst,
+		Text:                 text,
+		Encoded:              text.Encode(),
+		DataCoding:           coding,
+		Validity:             validity,
+		PriorityFlag:         1, // Priority in GSM, interactive in ANSI-136 and IS-95.
+		ReplaceIfPresentFlag: 1,
+	}
+	return sm, nil
+}
+
+// Errors returned by CDMAAlert.Apply for incoherent alerts.
+var (
+	ErrInvalidDisplayTime = errors.New("cdma alert: invalid display_time")
+	ErrSignalWithoutAlert = errors.New("cdma alert: sms_signal without alert_on_message_delivery")
+)
+
+// CDMAAlert builds the CDMA alerting TLVs of a short message, such as
+// of a message waiting indication: the sms_signal that alerts the
+// user, when the message is displayed, and whether to alert the user
+// on delivery at all.
+type CDMAAlert struct {
+	signal  *uint16
+	display *pdufield.DisplayMode
+	alert   bool
+}
+
+// NewCDMAAlert returns a new CDMAAlert builder.
+func NewCDMAAlert() *CDMAAlert {
+	return &CDMAAlert{}
+}
+
+// Signal sets the sms_signal, the alerting signal as encoded by the
+// network. It requires Alert.
+func (a *CDMAAlert) Signal(signal uint16) *CDMAAlert {
+	a.signal = &signal
+	return a
+}
+
+// DisplayTime sets the display_time.
+func (a *CDMAAlert) DisplayTime(d pdufield.DisplayMode) *CDMAAlert {
+	a.display = &d
+	return a
+}
+
+// Alert sets alert_on_message_delivery, requesting the handset to
+// alert the user on delivery.
+func (a *CDMAAlert) Alert() *CDMAAlert {
+	a.alert = true
+	return a
+}
+
+// Apply validates the alert and sets its TLVs on sm.
+func (a *CDMAAlert) Apply(sm *ShortMessage) error {
+	if a.display != nil && *a.display > pdufield.DisplayInvoke {
+		return ErrInvalidDisplayTime
+	}
+	if a.signal != nil && !a.alert {
+		return ErrSignalWithoutAlert
+	}
+	if sm.TLVFields == nil {
+		sm.TLVFields = make(pdufield.TLVMap)
+	}
+	if a.signal != nil {
+		sm.TLVFields.SetSmsSignal(*a.signal)
+	}
+	if a.display != nil {
+		sm.TLVFields.SetDisplayTime(*a.display)
+	}
+	if a.alert {
+		sm.TLVFields.SetAlertOnMessageDelivery()
+	}
+	return nil
+}
+
+// Errors returned by NewITSReply.
+var (
+	ErrNoSessionInfo    = errors.New("its reply: message has no its_session_info")
+	ErrInvalidReplyType = errors.New("its reply: invalid its_reply_type")
+	ErrNoReplyAddr      = errors.New("its reply: message has no source_addr or destination_addr")
+)
+
+// NewITSReply returns a ShortMessage replying to mo, a deliver_sm or
+// data_sm of an interactive teleservice session, asking the user for
+// input of the given its_reply_type. The reply is addressed back to the
+// source of mo, and its its_session_info continues the session of mo
+// with the next sequence number. To end the session instead, set its
+// its_session_info End field before submitting it.
+func NewITSReply(mo pdu.Body, text pdutext.Codec, rt pdufield.ReplyType) (*ShortMessage, error) {
+	if rt > pdufield.ReplyContinue {
+		return nil, ErrInvalidReplyType
+	}
+	si, ok := mo.TLVFields().ItsSessionInfo()
+	if !ok {
+		return nil, ErrNoSessionInfo
+	}
+	f := mo.Fields()
+	src, ok := f[pdufield.DestinationAddr]
+	if !ok || src == nil {
+		return nil, ErrNoReplyAddr
+	}
+	dst, ok := f[pdufield.SourceAddr]
+	if !ok || dst == nil {
+		return nil, ErrNoReplyAddr
+	}
+	sm := &ShortMessage{
+		Src:       src.String(),
+		Dst:       dst.String(),
+		Text:      text,
+		TLVFields: make(pdufield.TLVMap),
+	}
+	if v, ok := f[pdufield.DestAddrTON]; ok {
+		sm.SourceAddrTON, _ = v.Raw().(uint8)
+	}
+	if v, ok := f[pdufield.DestAddrNPI]; ok {
+		sm.SourceAddrNPI, _ = v.Raw().(uint8)
+	}
+	if v, ok := f[pdufield.SourceAddrTON]; ok {
+		sm.DestAddrTON, _ = v.Raw().(uint8)
+	}
+	if v, ok := f[pdufield.SourceAddrNPI]; ok {
+		sm.DestAddrNPI, _ = v.Raw().(uint8)
+	}
+	sm.TLVFields.SetItsReplyType(rt)
+	sm.TLVFields.SetItsSessionInfo(pdufield.SessionInfo{
+		Number: si.Number,
+		Seq:    (si.Seq + 1) & 0x7F,
+	})
+	return sm, nil
+}
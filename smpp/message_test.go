@@ -0,0 +1,296 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+	"github.com/fiorix/go-smpp/smpp/smpptest"
+)
+
+func TestSecureMessage(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	sm := NewSecureMessage("root", "foobar", pdutext.Raw("Lorem ipsum"), pdufield.PrivacyConfidential, 0x7F)
+	if _, err := tr.Submit(sm); err != nil {
+		t.Fatal(err)
+	}
+	p := <-pc
+	if pid := p.Fields()[pdufield.ProtocolID].Raw().(uint8); pid != 0x7F {
+		t.Fatalf("unexpected protocol_id: want 0x7f, have %#x", pid)
+	}
+	tlv := p.TLVFields()
+	if privacy, ok := tlv.PrivacyIndicator(); !ok || privacy != pdufield.PrivacyConfidential {
+		t.Fatalf("unexpected privacy_indicator: want %d, have %d (%t)", pdufield.PrivacyConfidential, privacy, ok)
+	}
+	if !tlv.AlertOnMessageDelivery() {
+		t.Fatal("missing alert_on_message_delivery")
+	}
+	var b bytes.Buffer
+	if err := p.SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x02, 0x01, 0x00, 0x01, 0x02, 0x13, 0x0C, 0x00, 0x00}
+	if have := b.Bytes()[b.Len()-len(want):]; !bytes.Equal(want, have) {
+		t.Fatalf("unexpected TLVs: want %#v, have %#v", want, have)
+	}
+}
+
+func TestCDMAAlert(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	sm := &ShortMessage{
+		Src:  "root",
+		Dst:  "foobar",
+		Text: pdutext.Raw("Lorem ipsum"),
+	}
+	err := NewCDMAAlert().Signal(0x0102).DisplayTime(pdufield.DisplayInvoke).Alert().Apply(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Submit(sm); err != nil {
+		t.Fatal(err)
+	}
+	p := <-pc
+	tlv := p.TLVFields()
+	if signal, ok := tlv.SmsSignal(); !ok || signal != 0x0102 {
+		t.Fatalf("unexpected sms_signal: want 0x0102, have %#x (%t)", signal, ok)
+	}
+	if d, ok := tlv.DisplayTime(); !ok || d != pdufield.DisplayInvoke {
+		t.Fatalf("unexpected display_time: want %d, have %d (%t)", pdufield.DisplayInvoke, d, ok)
+	}
+	if !tlv.AlertOnMessageDelivery() {
+		t.Fatal("missing alert_on_message_delivery")
+	}
+	var b bytes.Buffer
+	if err := p.SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x12, 0x01, 0x00, 0x01, 0x02, // display_time
+		0x12, 0x03, 0x00, 0x02, 0x01, 0x02, // sms_signal
+		0x13, 0x0C, 0x00, 0x00, // alert_on_message_delivery
+	}
+	if have := b.Bytes()[b.Len()-len(want):]; !bytes.Equal(want, have) {
+		t.Fatalf("unexpected TLVs: want %#v, have %#v", want, have)
+	}
+	test := []struct {
+		alert *CDMAAlert
+		want  error
+	}{
+		{NewCDMAAlert().Signal(0x0102), ErrSignalWithoutAlert},
+		{NewCDMAAlert().DisplayTime(0x03).Alert(), ErrInvalidDisplayTime},
+	}
+	for _, tc := range test {
+		sm := &ShortMessage{}
+		if err := tc.alert.Apply(sm); err != tc.want {
+			t.Fatalf("unexpected error: want %v, have %v", tc.want, err)
+		}
+		if len(sm.TLVFields) != 0 {
+			t.Fatalf("unexpected TLVs of invalid alert: %#v", sm.TLVFields)
+		}
+	}
+}
+
+func TestITSReply(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	mo := pdu.NewDeliverSM()
+	mo.Fields().Set(pdufield.SourceAddr, "5551234")
+	mo.Fields().Set(pdufield.SourceAddrTON, 0x01)
+	mo.Fields().Set(pdufield.DestinationAddr, "root")
+	mo.Fields().Set(pdufield.ShortMessage, pdutext.Raw("balance"))
+	mo.TLVFields().SetItsSessionInfo(pdufield.SessionInfo{Number: 7, Seq: 3})
+	sm, err := NewITSReply(mo, pdutext.Raw("Enter PIN"), pdufield.ReplyPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Src != "root" || sm.Dst != "5551234" || sm.DestAddrTON != 0x01 {
+		t.Fatalf("unexpected reply address: %#v", sm)
+	}
+	if _, err := tr.Submit(sm); err != nil {
+		t.Fatal(err)
+	}
+	p := <-pc
+	tlv := p.TLVFields()
+	if rt, ok := tlv.ItsReplyType(); !ok || rt != pdufield.ReplyPassword {
+		t.Fatalf("unexpected its_reply_type: want %d, have %d (%t)", pdufield.ReplyPassword, rt, ok)
+	}
+	want := pdufield.SessionInfo{Number: 7, Seq: 4}
+	if si, ok := tlv.ItsSessionInfo(); !ok || si != want {
+		t.Fatalf("unexpected its_session_info: want %#v, have %#v (%t)", want, si, ok)
+	}
+	var b bytes.Buffer
+	if err := p.SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	wire := []byte{
+		0x13, 0x80, 0x00, 0x01, 0x03, // its_reply_type
+		0x13, 0x83, 0x00, 0x02, 0x07, 0x08, // its_session_info
+	}
+	if have := b.Bytes()[b.Len()-len(wire):]; !bytes.Equal(wire, have) {
+		t.Fatalf("unexpected TLVs: want %#v, have %#v", wire, have)
+	}
+	if _, err := NewITSReply(mo, pdutext.Raw("?"), 0x09); err != ErrInvalidReplyType {
+		t.Fatalf("unexpected error: want %v, have %v", ErrInvalidReplyType, err)
+	}
+	if _, err := NewITSReply(pdu.NewDeliverSM(), pdutext.Raw("?"), pdufield.ReplyDigit); err != ErrNoSessionInfo {
+		t.Fatalf("unexpected error: want %v, have %v", ErrNoSessionInfo, err)
+	}
+	noAddr := pdu.NewDeliverSM()
+	noAddr.TLVFields().SetItsSessionInfo(pdufield.SessionInfo{Number: 7, Seq: 3})
+	if _, err := NewITSReply(noAddr, pdutext.Raw("?"), pdufield.ReplyDigit); err != ErrNoReplyAddr {
+		t.Fatalf("unexpected error: want %v, have %v", ErrNoReplyAddr, err)
+	}
+}
+
+func TestOTPMessage(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	sm, err := NewOTPMessage("root", "foobar", pdutext.GSM7("Your code is 1234"), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := tr.Submit(sm); err != nil {
+		t.Fatal(err)
+	}
+	f := (<-pc).Fields()
+	test := []struct {
+		name pdufield.Name
+		want uint8
+	}{
+		{pdufield.DataCoding, 0x10},
+		{pdufield.PriorityFlag, 1},
+		{pdufield.ReplaceIfPresentFlag, 1},
+	}
+	for _, tc := range test {
+		if have := f[tc.name].Raw().(uint8); have != tc.want {
+			t.Fatalf("unexpected %s: want %#x, have %#x", tc.name, tc.want, have)
+		}
+	}
+	if text := f[pdufield.ShortMessage].String(); text != "Your code is 1234" {
+		t.Fatalf("unexpected short_message: %q", text)
+	}
+	vp, err := ParseSMPPTime(f[pdufield.ValidityPeriod].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := vp.Sub(start); d < 5*time.Minute-time.Second || d > 5*time.Minute+time.Second {
+		t.Fatalf("unexpected validity_period: want 5m from now, have %s", d)
+	}
+	if _, err := NewOTPMessage("root", "foobar", pdutext.Latin1("olá"), time.Minute); err != ErrNoFlashClass {
+		t.Fatalf("unexpected error: want %v, have %v", ErrNoFlashClass, err)
+	}
+}
@@ -8,13 +8,32 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"sync/atomic"
 
 	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
 )
 
+// MaxSeq is the highest sequence number allowed by the spec. After
+// MaxSeq, sequence numbers wrap around to 1.
+const MaxSeq = 0x7FFFFFFF
+
 var nextSeq uint32
 
+// NextSeq returns the next sequence number for new PDUs.
+func NextSeq() uint32 {
+	for {
+		cur := atomic.LoadUint32(&nextSeq)
+		seq := cur + 1
+		if seq > MaxSeq {
+			seq = 1
+		}
+		if atomic.CompareAndSwapUint32(&nextSeq, cur, seq) {
+			return seq
+		}
+	}
+}
+
 // codec is the base type of all PDUs.
 // It implements the PDU interface and provides a generic encoder.
 type codec struct {
@@ -22,6 +41,9 @@ type codec struct {
 	l pdufield.List
 	f pdufield.Map
 	t pdufield.TLVMap
+
+	// binary data the PDU was decoded from, if any.
+	data []byte
 }
 
 // init initializes the codec's list and maps and sets the header
@@ -33,13 +55,33 @@ func (pdu *codec) init() {
 	pdu.f = make(pdufield.Map)
 	pdu.t = make(pdufield.TLVMap)
 	if pdu.h.Seq == 0 { // If Seq not set
-		pdu.h.Seq = atomic.AddUint32(&nextSeq, 1)
+		pdu.h.Seq = NextSeq()
 	}
 }
 
-// setup replaces the codec's current maps with the given ones.
-func (pdu *codec) setup(f pdufield.Map, t pdufield.TLVMap) {
-	pdu.f, pdu.t = f, t
+// setup replaces the codec's current maps with the given ones, and
+// the binary data they were decoded from.
+func (pdu *codec) setup(f pdufield.Map, t pdufield.TLVMap, raw []byte) {
+	pdu.f, pdu.t, pdu.data = f, t, raw
+}
+
+// raw returns the binary data the PDU was decoded from.
+func (pdu *codec) raw() []byte {
+	return pdu.data
+}
+
+// Raw returns the binary data p was decoded from, header included,
+// exactly as received, e.g. to forward it verbatim. Changes made to p
+// after decoding are not reflected, and SerializeTo may not reproduce
+// these bytes: TLVs are serialized sorted by tag, for one. It returns
+// nil if p was not returned by Decode.
+func Raw(p Body) []byte {
+	if c, ok := p.(interface {
+		raw() []byte
+	}); ok {
+		return c.raw()
+	}
+	return nil
 }
 
 // Header implements the PDU interface.
@@ -50,11 +92,13 @@ func (pdu *codec) Header() *Header {
 // Len implements the PDU interface.
 func (pdu *codec) Len() int {
 	l := HeaderLen
-	for _, f := range pdu.f {
-		l += f.Len()
+	for _, k := range pdu.l {
+		if f, ok := pdu.f[k]; ok {
+			l += f.Len()
+		}
 	}
 	for _, t := range pdu.t {
-		l += int(t.Len)
+		l += 4 + int(t.Len)
 	}
 	return l
 }
@@ -80,6 +124,11 @@ func (pdu *codec) SerializeTo(w io.Writer) error {
 	for _, k := range pdu.FieldList() {
 		f, ok := pdu.f[k]
 		if !ok {
+			switch k {
+			case pdufield.UDHLength, pdufield.GSMUserData:
+				// Only present when esm_class has the UDHI flag set.
+				continue
+			}
 			pdu.f.Set(k, nil)
 			f = pdu.f[k]
 		}
@@ -87,6 +136,17 @@ func (pdu *codec) SerializeTo(w io.Writer) error {
 			return err
 		}
 	}
+	// TLVs have no mandatory order, serialize them sorted by tag.
+	tags := make([]int, 0, len(pdu.t))
+	for tag := range pdu.t {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+	for _, tag := range tags {
+		if err := pdu.t[pdufield.TLVTag(tag)].SerializeTo(&b); err != nil {
+			return err
+		}
+	}
 	pdu.h.Len = uint32(pdu.Len())
 	err := pdu.h.SerializeTo(w)
 	if err != nil {
@@ -100,21 +160,24 @@ func (pdu *codec) SerializeTo(w io.Writer) error {
 // used for initializing new PDUs with map data decoded off the wire.
 type decoder interface {
 	Body
-	setup(f pdufield.Map, t pdufield.TLVMap)
+	setup(f pdufield.Map, t pdufield.TLVMap, raw []byte)
 }
 
-func decodeFields(pdu decoder, b []byte) (Body, error) {
+func decodeFields(pdu decoder, b []byte, p *pdufield.SMLengthPolicy) (Body, error) {
 	l := pdu.FieldList()
 	r := bytes.NewBuffer(b)
-	f, err := l.Decode(r)
+	f, err := l.DecodeWith(r, p)
 	if err != nil {
 		return nil, err
 	}
-	t := pdu.TLVFields()
-	if err = t.Decode(r); err != nil {
+	t, err := pdufield.DecodeTLVMap(r)
+	if err != nil {
 		return nil, err
 	}
-	pdu.setup(f, t)
+	var raw bytes.Buffer
+	pdu.Header().SerializeTo(&raw)
+	raw.Write(b)
+	pdu.setup(f, t, raw.Bytes())
 	return pdu, nil
 }
 
@@ -122,6 +185,12 @@ func decodeFields(pdu decoder, b []byte) (Body, error) {
 // with header and all fields decoded. The returned PDU can be modified
 // and re-serialized to its binary form.
 func Decode(r io.Reader) (Body, error) {
+	return DecodeWith(r, nil)
+}
+
+// DecodeWith is like Decode, but handles a sm_length that disagrees
+// with the short message as set by p, if not nil.
+func DecodeWith(r io.Reader, p *pdufield.SMLengthPolicy) (Body, error) {
 	hdr, err := DecodeHeader(r)
 	if err != nil {
 		return nil, err
@@ -133,51 +202,51 @@ func Decode(r io.Reader) (Body, error) {
 	}
 	switch hdr.ID {
 	case AlertNotificationID:
-		// TODO(fiorix): Implement AlertNotification.
+		return decodeFields(newAlertNotification(hdr), b, p)
 	case BindReceiverID, BindTransceiverID, BindTransmitterID:
-		return decodeFields(newBind(hdr), b)
+		return decodeFields(newBind(hdr), b, p)
 	case BindReceiverRespID, BindTransceiverRespID, BindTransmitterRespID:
-		return decodeFields(newBindResp(hdr), b)
+		return decodeFields(newBindResp(hdr), b, p)
 	case CancelSMID:
 		// TODO(fiorix): Implement CancelSM.
 	case CancelSMRespID:
 		// TODO(fiorix): Implement CancelSMResp.
 	case DataSMID:
-		// TODO(fiorix): Implement DataSM.
+		return decodeFields(newDataSM(hdr), b, p)
 	case DataSMRespID:
-		// TODO(fiorix): Implement DataSMResp.
+		return decodeFields(newDataSMResp(hdr), b, p)
 	case DeliverSMID:
-		return decodeFields(newDeliverSM(hdr), b)
+		return decodeFields(newDeliverSM(hdr), b, p)
 	case DeliverSMRespID:
-		return decodeFields(newDeliverSMResp(hdr), b)
+		return decodeFields(newDeliverSMResp(hdr), b, p)
 	case EnquireLinkID:
-		return decodeFields(newEnquireLink(hdr), b)
+		return decodeFields(newEnquireLink(hdr), b, p)
 	case EnquireLinkRespID:
-		return decodeFields(newEnquireLinkResp(hdr), b)
+		return decodeFields(newEnquireLinkResp(hdr), b, p)
 	case GenericNACKID:
-		return decodeFields(newGenericNACK(hdr), b)
+		return decodeFields(newGenericNACK(hdr), b, p)
 	case OutbindID:
 		// TODO(fiorix): Implement Outbind.
 	case QuerySMID:
-		return decodeFields(newQuerySM(hdr), b)
+		return decodeFields(newQuerySM(hdr), b, p)
 	case QuerySMRespID:
-		return decodeFields(newQuerySMResp(hdr), b)
+		return decodeFields(newQuerySMResp(hdr), b, p)
 	case ReplaceSMID:
 		// TODO(fiorix): Implement ReplaceSM.
 	case ReplaceSMRespID:
 		// TODO(fiorix): Implement ReplaceSMResp.
 	case SubmitMultiID:
-		return decodeFields(newSubmitMulti(hdr), b)
+		return decodeFields(newSubmitMulti(hdr), b, p)
 	case SubmitMultiRespID:
-		return decodeFields(newSubmitMultiResp(hdr), b)
+		return decodeFields(newSubmitMultiResp(hdr), b, p)
 	case SubmitSMID:
-		return decodeFields(newSubmitSM(hdr), b)
+		return decodeFields(newSubmitSM(hdr), b, p)
 	case SubmitSMRespID:
-		return decodeFields(newSubmitSMResp(hdr), b)
+		return decodeFields(newSubmitSMResp(hdr), b, p)
 	case UnbindID:
-		return decodeFields(newUnbind(hdr), b)
+		return decodeFields(newUnbind(hdr), b, p)
 	case UnbindRespID:
-		return decodeFields(newUnbindResp(hdr), b)
+		return decodeFields(newUnbindResp(hdr), b, p)
 	default:
 		return nil, fmt.Errorf("unknown PDU type: %#x", hdr.ID)
 	}
@@ -0,0 +1,91 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package pdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+)
+
+func TestNextSeqWraparound(t *testing.T) {
+	seq := atomic.LoadUint32(&nextSeq)
+	defer atomic.StoreUint32(&nextSeq, seq)
+	atomic.StoreUint32(&nextSeq, MaxSeq-1)
+	for _, want := range []uint32{MaxSeq, 1, 2} {
+		if have := NextSeq(); have != want {
+			t.Fatalf("unexpected seq: want %#x, have %#x", want, have)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	p := NewDeliverSM()
+	p.Fields().Set(pdufield.SourceAddr, "root")
+	p.Fields().Set(pdufield.DestinationAddr, "foobar")
+	p.Fields().Set(pdufield.ShortMessage, "Lorem ipsum")
+	var b bytes.Buffer
+	if err := p.SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	// TLVs in descending tag order, as sent by some SMSC: re-serializing
+	// the decoded PDU would sort them.
+	b.Write([]byte{0x14, 0x01, 0x00, 0x03, 'a', 'b', 'c'})
+	b.Write([]byte{0x04, 0x27, 0x00, 0x01, 0x02})
+	b.Write([]byte{0x00, 0x1E, 0x00, 0x04, 'f', 'o', 'o', 0x00})
+	want := b.Bytes()
+	binary.BigEndian.PutUint32(want, uint32(len(want)))
+	p, err := Decode(bytes.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.TLVFields()); n != 3 {
+		t.Fatalf("unexpected number of TLVs: want 3, have %d", n)
+	}
+	p.Header().Seq++
+	if have := Raw(p); !bytes.Equal(want, have) {
+		t.Fatalf("unexpected raw bytes:\nwant: %#x\nhave: %#x", want, have)
+	}
+	if have := Raw(NewDeliverSM()); have != nil {
+		t.Fatalf("unexpected raw bytes of new PDU: %#x", have)
+	}
+}
+
+// benchTLVs is a deliver_sm with 15 TLVs, as sent by vendor-heavy
+// carriers.
+func benchTLVs(b *testing.B) []byte {
+	p := NewDeliverSM()
+	f := p.Fields()
+	f.Set(pdufield.SourceAddr, "5551234")
+	f.Set(pdufield.DestinationAddr, "root")
+	f.Set(pdufield.ShortMessage, "Lorem ipsum")
+	tlv := p.TLVFields()
+	for i := 0; i < 15; i++ {
+		tlv.Set(pdufield.TLVTag(0x1400+i), "vendor value")
+	}
+	var buf bytes.Buffer
+	if err := p.SerializeTo(&buf); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeTLVs(b *testing.B) {
+	data := benchTLVs(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(p.TLVFields()) != 15 {
+			b.Fatalf("unexpected number of TLVs: %d", len(p.TLVFields()))
+		}
+	}
+}
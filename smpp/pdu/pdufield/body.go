@@ -26,6 +26,8 @@ func New(n Name, data []byte) Body {
 		DestAddrNPI,
 		DestAddrTON,
 		ESMClass,
+		ESMEAddrNPI,
+		ESMEAddrTON,
 		ErrorCode,
 		InterfaceVersion,
 		MessageState,
@@ -48,6 +50,7 @@ func New(n Name, data []byte) Body {
 		AddressRange,
 		DestinationAddr,
 		DestinationList,
+		ESMEAddr,
 		FinalDate,
 		MessageID,
 		Password,
@@ -69,19 +72,19 @@ func New(n Name, data []byte) Body {
 		return &SM{Data: data}
 	case GSMUserData:
 		udhData := []UDH{}
-		if data != nil && len(data) > 2 {
-			for i := 0; i < len(data); {
-				udh := UDH{}
-				udh.IEI = Fixed{Data: data[i]}
-				udh.IELength = Fixed{Data: data[i+1]}
-				udh.IEData = Variable{}
-				l := int(data[i+1])
-				for j := 2; j < l+2; j++ {
-					udh.IEData.Data = append(udh.IEData.Data, data[i+j])
-				}
-				udhData = append(udhData, udh)
-				i += l + 3 // Ignore one byte after IEData (which is 0x00)
+		// Information elements as serialized by UDHList, without
+		// the length of the header.
+		for i := 0; i+2 <= len(data); {
+			l := int(data[i+1])
+			if i+2+l > len(data) {
+				break
 			}
+			udh := UDH{}
+			udh.IEI = Fixed{Data: data[i]}
+			udh.IELength = Fixed{Data: data[i+1]}
+			udh.IEData = Variable{Data: append([]byte{}, data[i+2:i+2+l]...)}
+			udhData = append(udhData, udh)
+			i += l + 2
 		}
 		return &UDHList{Data: udhData}
 	default:
@@ -6,10 +6,69 @@ package pdufield
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"sync/atomic"
 )
 
+// SMLengthPolicy is how Decode handles a sm_length that disagrees with
+// the short message data in the PDU, which some carriers send.
+type SMLengthPolicy struct {
+	// Strict makes Decode fail such PDUs, instead of decoding the
+	// short message that is actually there.
+	Strict bool
+
+	// Mismatch is called, if not nil, with sm_length and the actual
+	// length of the data, both including the UDH, if any. It may be
+	// called concurrently when the policy is shared, e.g. by clients.
+	Mismatch func(smLength, have int)
+}
+
+var smLengthMismatches uint64
+
+// SMLengthMismatches returns the number of short messages decoded with
+// a sm_length that disagrees with their data, since the program started.
+func SMLengthMismatches() uint64 {
+	return atomic.LoadUint64(&smLengthMismatches)
+}
+
+// mismatch records a short message of length have, for which
+// sm_length said smLength.
+func (p *SMLengthPolicy) mismatch(smLength, have int) {
+	atomic.AddUint64(&smLengthMismatches, 1)
+	if p != nil && p.Mismatch != nil {
+		p.Mismatch(smLength, have)
+	}
+}
+
+// smAvailable returns the length of the short message data at the
+// start of b, the rest of a PDU body, that is up to the TLVs after it.
+func smAvailable(b []byte) int {
+	for i := 0; i < len(b); i++ {
+		if isTLVs(b[i:]) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// isTLVs reports whether b is a sequence of whole TLVs, none with the
+// reserved tag 0.
+func isTLVs(b []byte) bool {
+	for len(b) > 0 {
+		if len(b) < 4 || binary.BigEndian.Uint16(b) == 0 {
+			return false
+		}
+		l := 4 + int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < l {
+			return false
+		}
+		b = b[l:]
+	}
+	return true
+}
+
 // List is a list of PDU fields.
 type List []Name
 
@@ -18,7 +77,17 @@ type List []Name
 // If the ShortMessage field is present, and DataCoding as well,
 // we attempt to decode text automatically. See pdutext package
 // for more information.
+//
+// When sm_length disagrees with the data left in the buffer, which
+// some carriers send, the short message is decoded from the data up
+// to the TLVs after it, if any, and SMLength set to its actual length.
 func (l List) Decode(r *bytes.Buffer) (Map, error) {
+	return l.DecodeWith(r, nil)
+}
+
+// DecodeWith is like Decode, but handles a sm_length that disagrees
+// with the data as set by p, if not nil.
+func (l List) DecodeWith(r *bytes.Buffer, p *SMLengthPolicy) (Map, error) {
 	var (
 		unsuccessCount, numDest, udhLength, smLength int
 
@@ -31,6 +100,7 @@ loop:
 		case
 			AddressRange,
 			DestinationAddr,
+			ESMEAddr,
 			ErrorCode,
 			FinalDate,
 			MessageID,
@@ -57,6 +127,8 @@ loop:
 			DestAddrNPI,
 			DestAddrTON,
 			ESMClass,
+			ESMEAddrNPI,
+			ESMEAddrTON,
 			InterfaceVersion,
 			NumberDests,
 			NoUnsuccess,
@@ -217,19 +289,28 @@ loop:
 			}
 			f[k] = &UnSmeList{Data: unsList}
 		case ShortMessage:
-			// Check UDHLength
+			// Octets of the UDH, already read, counted in sm_length
+			udhOctets := 0
 			if udhLength > 0 {
-				if smLength-udhLength-1 < 0 {
-					return nil, fmt.Errorf("smLength is lesser than udhLength+1: have %d and %d",
-						smLength, udhLength)
+				udhOctets = udhLength + 1
+			}
+			mismatch := smLength < udhOctets || r.Len() < smLength-udhOctets
+			if mismatch {
+				have := udhOctets + smAvailable(r.Bytes())
+				if p != nil && p.Strict {
+					if smLength < udhOctets {
+						return nil, fmt.Errorf("smLength is lesser than udhLength+1: have %d and %d",
+							smLength, udhLength)
+					}
+					return nil, fmt.Errorf("short read for smlength: want %d, have %d",
+						smLength, have)
 				}
-				smLength -= udhLength + 1
-				f[SMLength] = &Fixed{Data: byte(smLength)}
+				p.mismatch(smLength, have)
+				smLength = have
 			}
-			// Check SMLength
-			if r.Len() < smLength {
-				return nil, fmt.Errorf("short read for smlength: want %d, have %d",
-					smLength, r.Len())
+			if mismatch || udhOctets > 0 {
+				smLength -= udhOctets
+				f[SMLength] = &Fixed{Data: byte(smLength)}
 			}
 			f[ShortMessage] = &SM{Data: r.Next(smLength)}
 		}
@@ -134,3 +134,73 @@ func TestListDecoder_UnSmeList(t *testing.T) {
 		t.Fatalf("unexpected data: want %q, have %q, len %d", resUnSmeList, v, len(v.Data))
 	}
 }
+
+func TestListDecoder_SMLengthMismatch(t *testing.T) {
+	l := List{SMLength, ShortMessage}
+	data := []byte{0x0A, 'h', 'e', 'l', 'l', 'o'}
+	var want, have int
+	policy := &SMLengthPolicy{Mismatch: func(smLength, n int) { want, have = smLength, n }}
+	n := SMLengthMismatches()
+	m, err := l.DecodeWith(bytes.NewBuffer(data), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := m[ShortMessage].Bytes(); !bytes.Equal(v, []byte("hello")) {
+		t.Fatalf("unexpected data: want %q, have %q", "hello", v)
+	}
+	if v := m[SMLength].Bytes(); !bytes.Equal(v, []byte{0x05}) {
+		t.Fatalf("unexpected sm_length: want %#x, have %#x", 0x05, v)
+	}
+	if want != 10 || have != 5 {
+		t.Fatalf("unexpected mismatch: want 10 and 5, have %d and %d", want, have)
+	}
+	if SMLengthMismatches() != n+1 {
+		t.Fatalf("unexpected mismatch count: want %d, have %d", n+1, SMLengthMismatches())
+	}
+	if _, err := l.DecodeWith(bytes.NewBuffer(data), &SMLengthPolicy{Strict: true}); err == nil {
+		t.Fatal("unexpected decode with strict policy")
+	}
+	if _, err := l.Decode(bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("unexpected error without policy: %v", err)
+	}
+}
+
+func TestListDecoder_SMLengthMismatchTLV(t *testing.T) {
+	// user_message_reference, after the short message.
+	tlv := []byte{0x02, 0x04, 0x00, 0x02, 0x00, 0x01}
+	udh := []byte{0x05, 0x00, 0x03, 0x2a, 0x02, 0x01}
+	test := []struct {
+		l        List
+		data     []byte
+		sm       string
+		smLength int
+		have     int
+	}{
+		{List{SMLength, ShortMessage}, []byte{20, 'h', 'e', 'l', 'l', 'o'}, "hello", 20, 5},
+		// Shorter than the UDH, the text is still decoded.
+		{List{ESMClass, SMLength, UDHLength, GSMUserData, ShortMessage}, append(append([]byte{0x40, 3}, udh...), 'h', 'i'), "hi", 3, 8},
+		{List{ESMClass, SMLength, UDHLength, GSMUserData, ShortMessage}, append(append([]byte{0x40, 20}, udh...), 'h', 'i'), "hi", 20, 8},
+	}
+	for i, tc := range test {
+		var want, have int
+		policy := &SMLengthPolicy{Mismatch: func(smLength, n int) { want, have = smLength, n }}
+		r := bytes.NewBuffer(append(append([]byte{}, tc.data...), tlv...))
+		m, err := tc.l.DecodeWith(r, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := m[ShortMessage].Bytes(); string(v) != tc.sm {
+			t.Fatalf("unexpected data for test %d: want %q, have %q", i, tc.sm, v)
+		}
+		if want != tc.smLength || have != tc.have {
+			t.Fatalf("unexpected mismatch for test %d: want %d and %d, have %d and %d", i, tc.smLength, tc.have, want, have)
+		}
+		tm, err := DecodeTLVMap(r)
+		if err != nil {
+			t.Fatalf("unexpected error decoding TLVs for test %d: %v", i, err)
+		}
+		if ref, ok := tm.UserMessageReference(); !ok || ref != 1 || len(tm) != 1 {
+			t.Fatalf("unexpected TLVs for test %d: %#v", i, tm)
+		}
+	}
+}
@@ -51,6 +51,7 @@ const (
 	DeliveryFailureReason    TLVTag = 0x0425
 	MoreMessagesToSend       TLVTag = 0x0426
 	MessageStateOption       TLVTag = 0x0427
+	CongestionState          TLVTag = 0x0428
 	UssdServiceOp            TLVTag = 0x0501
 	DisplayTime              TLVTag = 0x1201
 	SmsSignal                TLVTag = 0x1203
@@ -81,6 +82,34 @@ func (tlv *TLVBody) Set(d []byte) *TLVBody {
 	return tlv
 }
 
+// SetStruct encodes v in network byte order and sets it as the TLV
+// data. The value v must be fixed-size, e.g. a struct of fixed-size
+// fields, as defined by encoding/binary. This is meant for vendor
+// specific TLVs carrying packed structures.
+func (tlv *TLVBody) SetStruct(v interface{}) error {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.BigEndian, v); err != nil {
+		return err
+	}
+	tlv.Set(b.Bytes())
+	return nil
+}
+
+// GetStruct decodes the TLV data into v, a pointer to a fixed-size
+// value. It returns error if the size of v does not match the
+// length of the TLV.
+func (tlv *TLVBody) GetStruct(v interface{}) error {
+	n := binary.Size(v)
+	if n < 0 {
+		return fmt.Errorf("tag %#x: not a fixed-size value: %T", tlv.Tag, v)
+	}
+	if n != int(tlv.Len) || n != len(tlv.data) {
+		return fmt.Errorf("tag %#x: size mismatch: want %d, have %d",
+			tlv.Tag, tlv.Len, n)
+	}
+	return binary.Read(bytes.NewReader(tlv.data), binary.BigEndian, v)
+}
+
 // SerializeTo serializes TLV data to its binary form.
 func (tlv *TLVBody) SerializeTo(w io.Writer) error {
 	b := make([]byte, 4+len(tlv.data))
@@ -94,8 +123,24 @@ func (tlv *TLVBody) SerializeTo(w io.Writer) error {
 // TLVMap is a collection of PDU TLV field data indexed by tag.
 type TLVMap map[TLVTag]*TLVBody
 
+// DecodeTLVMap scans the given byte buffer to build a new TLVMap from
+// binary data. Unlike Decode on an empty TLVMap, the map is sized for
+// the TLVs in the buffer upfront.
+func DecodeTLVMap(r *bytes.Buffer) (TLVMap, error) {
+	n := countTLVs(r.Bytes())
+	t := make(TLVMap, n)
+	return t, t.decode(r, n)
+}
+
 // Decode scans the given byte buffer to build a TLVMap from binary data.
 func (t TLVMap) Decode(r *bytes.Buffer) error {
+	return t.decode(r, countTLVs(r.Bytes()))
+}
+
+// decode decodes the n TLVs of r, and any others left, allocating the
+// TLVBody of the n in one go.
+func (t TLVMap) decode(r *bytes.Buffer, n int) error {
+	bodies := make([]TLVBody, n)
 	for r.Len() >= 4 {
 		b := r.Next(4)
 		ft := TLVTag(binary.BigEndian.Uint16(b[0:2]))
@@ -105,22 +150,39 @@ func (t TLVMap) Decode(r *bytes.Buffer) error {
 				ft, fl, r.Len())
 		}
 		b = r.Next(int(fl))
-		t[ft] = &TLVBody{
-			Tag:  ft,
-			Len:  fl,
-			data: b,
+		var tlv *TLVBody
+		if len(bodies) > 0 {
+			tlv, bodies = &bodies[0], bodies[1:]
+		} else {
+			tlv = &TLVBody{}
 		}
+		tlv.Tag, tlv.Len, tlv.data = ft, fl, b
+		t[ft] = tlv
 	}
 	return nil
 }
 
+// countTLVs returns the number of whole TLVs in b.
+func countTLVs(b []byte) int {
+	n := 0
+	for len(b) >= 4 {
+		l := 4 + int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < l {
+			break
+		}
+		b = b[l:]
+		n++
+	}
+	return n
+}
+
 // Set updates the PDU map with the given key and value, and
 // returns error if the value cannot be converted to type Data.
 //
 // This is a shortcut for m[k] = New(k, v) converting v properly.
 //
-// If k is ShortMessage and v is of type pdutext.Codec, text is
-// encoded and data_coding PDU and sm_length PDUs are set.
+// Values of type uint16 and uint32 are encoded in network byte order
+// as 2 and 4 octets, int and uint8 as a single octet.
 func (m TLVMap) Set(k TLVTag, v interface{}) error {
 	tlv := &TLVBody{Tag: k}
 	switch v.(type) {
@@ -128,6 +190,14 @@ func (m TLVMap) Set(k TLVTag, v interface{}) error {
 		m[k] = tlv.Set(nil)
 	case uint8:
 		m[k] = tlv.Set([]byte{v.(uint8)})
+	case uint16:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, v.(uint16))
+		m[k] = tlv.Set(b)
+	case uint32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v.(uint32))
+		m[k] = tlv.Set(b)
 	case int:
 		m[k] = tlv.Set([]byte{uint8(v.(int))})
 	case string:
@@ -141,3 +211,348 @@ func (m TLVMap) Set(k TLVTag, v interface{}) error {
 	}
 	return nil
 }
+
+// UserMessageReference returns the value of the user_message_reference
+// TLV, and whether it is present and well formed.
+func (m TLVMap) UserMessageReference() (uint16, bool) {
+	tlv, ok := m[UserMessageReference]
+	if !ok || len(tlv.data) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(tlv.data), true
+}
+
+// SetUserMessageReference sets the user_message_reference TLV.
+func (m TLVMap) SetUserMessageReference(ref uint16) {
+	m.Set(UserMessageReference, ref)
+}
+
+// UserResponseCode returns the value of the user_response_code TLV,
+// and whether it is present and well formed.
+func (m TLVMap) UserResponseCode() (uint8, bool) {
+	tlv, ok := m[UserResponseCode]
+	if !ok || len(tlv.data) != 1 {
+		return 0, false
+	}
+	return tlv.data[0], true
+}
+
+// SetUserResponseCode sets the user_response_code TLV.
+func (m TLVMap) SetUserResponseCode(code uint8) {
+	m.Set(UserResponseCode, code)
+}
+
+// TelematicsID is the value of the source_telematics_id and
+// dest_telematics_id TLVs.
+type TelematicsID struct {
+	Protocol     uint8 // Telematic protocol.
+	Interworking uint8 // Telematic interworking.
+}
+
+// SourceTelematicsID returns the value of the source_telematics_id
+// TLV, and whether it is present and well formed.
+func (m TLVMap) SourceTelematicsID() (TelematicsID, bool) {
+	return m.telematicsID(SourceTelematicsID)
+}
+
+// SetSourceTelematicsID sets the source_telematics_id TLV.
+func (m TLVMap) SetSourceTelematicsID(id TelematicsID) {
+	m.Set(SourceTelematicsID, []byte{id.Protocol, id.Interworking})
+}
+
+// DestTelematicsID returns the value of the dest_telematics_id TLV,
+// and whether it is present and well formed.
+func (m TLVMap) DestTelematicsID() (TelematicsID, bool) {
+	return m.telematicsID(DestTelematicsID)
+}
+
+// SetDestTelematicsID sets the dest_telematics_id TLV.
+func (m TLVMap) SetDestTelematicsID(id TelematicsID) {
+	m.Set(DestTelematicsID, []byte{id.Protocol, id.Interworking})
+}
+
+func (m TLVMap) telematicsID(k TLVTag) (TelematicsID, bool) {
+	tlv, ok := m[k]
+	if !ok || len(tlv.data) != 2 {
+		return TelematicsID{}, false
+	}
+	return TelematicsID{Protocol: tlv.data[0], Interworking: tlv.data[1]}, true
+}
+
+// Privacy is the value of the privacy_indicator TLV.
+type Privacy uint8
+
+// Supported privacy levels.
+const (
+	PrivacyNotRestricted Privacy = 0x00 // Privacy level 0 (default).
+	PrivacyRestricted    Privacy = 0x01 // Privacy level 1.
+	PrivacyConfidential  Privacy = 0x02 // Privacy level 2.
+	PrivacySecret        Privacy = 0x03 // Privacy level 3.
+)
+
+// PrivacyIndicator returns the value of the privacy_indicator TLV,
+// and whether it is present and well formed.
+func (m TLVMap) PrivacyIndicator() (Privacy, bool) {
+	tlv, ok := m[PrivacyIndicator]
+	if !ok || len(tlv.data) != 1 {
+		return PrivacyNotRestricted, false
+	}
+	return Privacy(tlv.data[0]), true
+}
+
+// SetPrivacyIndicator sets the privacy_indicator TLV.
+func (m TLVMap) SetPrivacyIndicator(p Privacy) {
+	m.Set(PrivacyIndicator, uint8(p))
+}
+
+// AlertOnMessageDelivery returns whether the alert_on_message_delivery
+// TLV is present. It has no value.
+func (m TLVMap) AlertOnMessageDelivery() bool {
+	_, ok := m[AlertOnMessageDelivery]
+	return ok
+}
+
+// SetAlertOnMessageDelivery sets the alert_on_message_delivery TLV,
+// requesting the handset to alert the user on delivery.
+func (m TLVMap) SetAlertOnMessageDelivery() {
+	m.Set(AlertOnMessageDelivery, nil)
+}
+
+// DisplayMode is the value of the display_time TLV.
+type DisplayMode uint8
+
+// Supported display modes.
+const (
+	DisplayTemporary DisplayMode = 0x00 // Temporary.
+	DisplayDefault   DisplayMode = 0x01 // Default.
+	DisplayInvoke    DisplayMode = 0x02 // Invoke.
+)
+
+// DisplayTime returns the value of the display_time TLV, and whether
+// it is present and well formed.
+func (m TLVMap) DisplayTime() (DisplayMode, bool) {
+	tlv, ok := m[DisplayTime]
+	if !ok || len(tlv.data) != 1 {
+		return DisplayDefault, false
+	}
+	return DisplayMode(tlv.data[0]), true
+}
+
+// SetDisplayTime sets the display_time TLV.
+func (m TLVMap) SetDisplayTime(d DisplayMode) {
+	m.Set(DisplayTime, uint8(d))
+}
+
+// SmsSignal returns the value of the sms_signal TLV, and whether it
+// is present and well formed.
+func (m TLVMap) SmsSignal() (uint16, bool) {
+	tlv, ok := m[SmsSignal]
+	if !ok || len(tlv.data) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(tlv.data), true
+}
+
+// SetSmsSignal sets the sms_signal TLV, the alerting signal of the
+// handset as encoded by the network.
+func (m TLVMap) SetSmsSignal(signal uint16) {
+	m.Set(SmsSignal, signal)
+}
+
+// ReplyType is the value of the its_reply_type TLV, the kind of user
+// input expected by an interactive teleservice.
+type ReplyType uint8
+
+// Supported reply types.
+const (
+	ReplyDigit         ReplyType = 0x00 // Digit.
+	ReplyNumber        ReplyType = 0x01 // Number.
+	ReplyTelephone     ReplyType = 0x02 // Telephone number.
+	ReplyPassword      ReplyType = 0x03 // Password.
+	ReplyCharacterLine ReplyType = 0x04 // Character line.
+	ReplyMenu          ReplyType = 0x05 // Menu.
+	ReplyDate          ReplyType = 0x06 // Date.
+	ReplyTime          ReplyType = 0x07 // Time.
+	ReplyContinue      ReplyType = 0x08 // Continue.
+)
+
+// ItsReplyType returns the value of the its_reply_type TLV, and
+// whether it is present and well formed.
+func (m TLVMap) ItsReplyType() (ReplyType, bool) {
+	tlv, ok := m[ItsReplyType]
+	if !ok || len(tlv.data) != 1 {
+		return ReplyDigit, false
+	}
+	return ReplyType(tlv.data[0]), true
+}
+
+// SetItsReplyType sets the its_reply_type TLV.
+func (m TLVMap) SetItsReplyType(t ReplyType) {
+	m.Set(ItsReplyType, uint8(t))
+}
+
+// SessionInfo is the value of the its_session_info TLV.
+type SessionInfo struct {
+	Number uint8 // Session number.
+	Seq    uint8 // Sequence number of the message in the session, 7 bits.
+	End    bool  // End of session.
+}
+
+// ItsSessionInfo returns the value of the its_session_info TLV, and
+// whether it is present and well formed.
+func (m TLVMap) ItsSessionInfo() (SessionInfo, bool) {
+	tlv, ok := m[ItsSessionInfo]
+	if !ok || len(tlv.data) != 2 {
+		return SessionInfo{}, false
+	}
+	return SessionInfo{
+		Number: tlv.data[0],
+		Seq:    tlv.data[1] >> 1,
+		End:    tlv.data[1]&0x01 != 0,
+	}, true
+}
+
+// SetItsSessionInfo sets the its_session_info TLV. Only the 7 low
+// bits of Seq are used.
+func (m TLVMap) SetItsSessionInfo(si SessionInfo) {
+	b := si.Seq << 1
+	if si.End {
+		b |= 0x01
+	}
+	m.Set(ItsSessionInfo, []byte{si.Number, b})
+}
+
+// MsAvailability is the value of the ms_availability_status TLV,
+// sent by the SMSC in alert_notification.
+type MsAvailability uint8
+
+// Supported MS availability status values.
+const (
+	MsAvailable   MsAvailability = 0x00 // Available (default).
+	MsDenied      MsAvailability = 0x01 // Denied, e.g. suspended or no SMS capability.
+	MsUnavailable MsAvailability = 0x02 // Unavailable.
+)
+
+var msAvailabilityText = map[MsAvailability]string{
+	MsAvailable:   "Available",
+	MsDenied:      "Denied",
+	MsUnavailable: "Unavailable",
+}
+
+// String implements the Stringer interface.
+func (s MsAvailability) String() string {
+	if v, ok := msAvailabilityText[s]; ok {
+		return v
+	}
+	return fmt.Sprintf("Reserved (%d)", uint8(s))
+}
+
+// MsAvailabilityStatus returns the value of the ms_availability_status
+// TLV. If the TLV is absent the MS is available, as per the spec.
+func (m TLVMap) MsAvailabilityStatus() MsAvailability {
+	tlv, ok := m[MsAvailabilityStatus]
+	if !ok || len(tlv.data) != 1 {
+		return MsAvailable
+	}
+	return MsAvailability(tlv.data[0])
+}
+
+// SetMsAvailabilityStatus sets the ms_availability_status TLV.
+func (m TLVMap) SetMsAvailabilityStatus(s MsAvailability) {
+	m.Set(MsAvailabilityStatus, uint8(s))
+}
+
+// State is the state of a short message, as in the
+// message_state TLV of deliver_sm and data_sm.
+type State uint8
+
+// Supported message states.
+const (
+	StateEnroute       State = 1
+	StateDelivered     State = 2
+	StateExpired       State = 3
+	StateDeleted       State = 4
+	StateUndeliverable State = 5
+	StateAccepted      State = 6
+	StateUnknown       State = 7
+	StateRejected      State = 8
+)
+
+var messageStateText = map[State]string{
+	StateEnroute:       "ENROUTE",
+	StateDelivered:     "DELIVERED",
+	StateExpired:       "EXPIRED",
+	StateDeleted:       "DELETED",
+	StateUndeliverable: "UNDELIVERABLE",
+	StateAccepted:      "ACCEPTED",
+	StateUnknown:       "UNKNOWN",
+	StateRejected:      "REJECTED",
+}
+
+// String implements the Stringer interface.
+func (s State) String() string {
+	if v, ok := messageStateText[s]; ok {
+		return v
+	}
+	return fmt.Sprintf("Reserved (%d)", uint8(s))
+}
+
+// MessageState returns the value of the message_state TLV, and
+// whether it is present and well formed.
+func (m TLVMap) MessageState() (State, bool) {
+	tlv, ok := m[MessageStateOption]
+	if !ok || len(tlv.data) != 1 {
+		return 0, false
+	}
+	return State(tlv.data[0]), true
+}
+
+// NetworkError is the value of the network_error_code TLV.
+type NetworkError struct {
+	Type uint8  // Network type, e.g. 1 for ANSI-136, 3 for GSM.
+	Code uint16 // Error code, specific to the network type.
+}
+
+// NetworkErrorCode returns the value of the network_error_code TLV,
+// and whether it is present and well formed.
+func (m TLVMap) NetworkErrorCode() (NetworkError, bool) {
+	tlv, ok := m[NetworkErrorCode]
+	if !ok || len(tlv.data) != 3 {
+		return NetworkError{}, false
+	}
+	return NetworkError{
+		Type: tlv.data[0],
+		Code: binary.BigEndian.Uint16(tlv.data[1:]),
+	}, true
+}
+
+// SetCallbackNumAtag sets the callback_num_atag TLV, the alpha tag
+// displayed with the callback number, encoded with the given codec.
+func (m TLVMap) SetCallbackNumAtag(tag pdutext.Codec) {
+	b := append([]byte{uint8(tag.Type())}, tag.Encode()...)
+	m.Set(CallbackNumAtag, b)
+}
+
+// CallbackNumAtag returns the alpha tag of the callback_num_atag TLV
+// as a codec for its data coding, and whether it is present. The
+// returned codec holds encoded text, call its Decode method for UTF-8.
+// Data coding 0x00 is decoded as the GSM 03.38 default alphabet.
+func (m TLVMap) CallbackNumAtag() (pdutext.Codec, bool) {
+	tlv, ok := m[CallbackNumAtag]
+	if !ok || len(tlv.data) < 1 {
+		return nil, false
+	}
+	text := tlv.data[1:]
+	switch pdutext.DataCoding(tlv.data[0]) {
+	case pdutext.DefaultType:
+		return pdutext.GSM7(text), true
+	case pdutext.Latin1Type:
+		return pdutext.Latin1(text), true
+	case pdutext.ISO88595Type:
+		return pdutext.ISO88595(text), true
+	case pdutext.UCS2Type:
+		return pdutext.UCS2(text), true
+	default:
+		return pdutext.Raw(text), true
+	}
+}
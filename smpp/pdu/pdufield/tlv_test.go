@@ -0,0 +1,170 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package pdufield
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+)
+
+func TestTLVStruct(t *testing.T) {
+	type counters struct {
+		Flags uint8
+		Count uint16
+	}
+	want := counters{Flags: 0x81, Count: 0x0102}
+	tlv := &TLVBody{Tag: 0x1400}
+	if err := tlv.SetStruct(want); err != nil {
+		t.Fatal(err)
+	}
+	wb := []byte{0x14, 0x00, 0x00, 0x03, 0x81, 0x01, 0x02}
+	var b bytes.Buffer
+	if err := tlv.SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wb, b.Bytes()) {
+		t.Fatalf("unexpected bytes: want %#v, have %#v", wb, b.Bytes())
+	}
+	m := make(TLVMap)
+	if err := m.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	var have counters
+	if err := m[0x1400].GetStruct(&have); err != nil {
+		t.Fatal(err)
+	}
+	if have != want {
+		t.Fatalf("unexpected struct: want %#v, have %#v", want, have)
+	}
+	var short struct{ Flags uint8 }
+	if err := m[0x1400].GetStruct(&short); err == nil {
+		t.Fatal("unexpected decode of mismatched struct size")
+	}
+	if err := tlv.SetStruct("not fixed size"); err == nil {
+		t.Fatal("unexpected encode of variable size value")
+	}
+}
+
+func TestTLVCallbackNumAtag(t *testing.T) {
+	want := "Voicemail @ 5€"
+	m := make(TLVMap)
+	m.SetCallbackNumAtag(pdutext.GSM7(want))
+	wb := []byte{0x03, 0x03, 0x00, 0x10, 0x00}
+	wb = append(wb, "Voicemail \x00 5\x1be"...)
+	var b bytes.Buffer
+	if err := m[CallbackNumAtag].SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wb, b.Bytes()) {
+		t.Fatalf("unexpected bytes: want %q, have %q", wb, b.Bytes())
+	}
+	r := make(TLVMap)
+	if err := r.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	tag, ok := r.CallbackNumAtag()
+	if !ok {
+		t.Fatalf("missing callback_num_atag: %#v", r)
+	}
+	if tag.Type() != pdutext.DefaultType {
+		t.Fatalf("unexpected data coding: want %#x, have %#x", pdutext.DefaultType, tag.Type())
+	}
+	if have := string(tag.Decode()); have != want {
+		t.Fatalf("unexpected alpha tag: want %q, have %q", want, have)
+	}
+}
+
+func TestTLVTelematicsID(t *testing.T) {
+	src := TelematicsID{Protocol: 0x01, Interworking: 0x02}
+	dst := TelematicsID{Protocol: 0x03, Interworking: 0x04}
+	m := make(TLVMap)
+	m.SetSourceTelematicsID(src)
+	m.SetDestTelematicsID(dst)
+	var b bytes.Buffer
+	for _, k := range []TLVTag{DestTelematicsID, SourceTelematicsID} {
+		if err := m[k].SerializeTo(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wb := []byte{0x00, 0x08, 0x00, 0x02, 0x03, 0x04, 0x00, 0x10, 0x00, 0x02, 0x01, 0x02}
+	if !bytes.Equal(wb, b.Bytes()) {
+		t.Fatalf("unexpected bytes: want %#v, have %#v", wb, b.Bytes())
+	}
+	r := make(TLVMap)
+	if err := r.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if have, ok := r.SourceTelematicsID(); !ok || have != src {
+		t.Fatalf("unexpected source_telematics_id: want %#v, have %#v", src, have)
+	}
+	if have, ok := r.DestTelematicsID(); !ok || have != dst {
+		t.Fatalf("unexpected dest_telematics_id: want %#v, have %#v", dst, have)
+	}
+	if _, ok := make(TLVMap).DestTelematicsID(); ok {
+		t.Fatal("unexpected dest_telematics_id in empty map")
+	}
+}
+
+func TestTLVSetUint16(t *testing.T) {
+	m := make(TLVMap)
+	m.Set(DestTelematicsID, uint16(0x0304))
+	if v := m[DestTelematicsID]; v.Len != 2 || !bytes.Equal(v.Bytes(), []byte{0x03, 0x04}) {
+		t.Fatalf("unexpected uint16 TLV: %#v", v)
+	}
+	m.Set(QosTimeToLive, uint32(0x01020304))
+	if v := m[QosTimeToLive]; v.Len != 4 || !bytes.Equal(v.Bytes(), []byte{0x01, 0x02, 0x03, 0x04}) {
+		t.Fatalf("unexpected uint32 TLV: %#v", v)
+	}
+}
+
+func TestDecodeTLVMap(t *testing.T) {
+	var b bytes.Buffer
+	for i := 0; i < 15; i++ {
+		tlv := &TLVBody{Tag: TLVTag(0x1400 + i)}
+		tlv.Set(bytes.Repeat([]byte{byte(i)}, i))
+		if err := tlv.SerializeTo(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Repeated tag, the last one wins.
+	tlv := &TLVBody{Tag: 0x1400}
+	tlv.Set([]byte("last"))
+	if err := tlv.SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	m, err := DecodeTLVMap(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 15 {
+		t.Fatalf("unexpected number of TLVs: want 15, have %d", len(m))
+	}
+	for i := 1; i < 15; i++ {
+		tlv := m[TLVTag(0x1400+i)]
+		want := bytes.Repeat([]byte{byte(i)}, i)
+		if tlv == nil || tlv.Tag != TLVTag(0x1400+i) || int(tlv.Len) != i || !bytes.Equal(tlv.Bytes(), want) {
+			t.Fatalf("unexpected TLV %#x: want %v, have %#v", 0x1400+i, want, tlv)
+		}
+	}
+	if v := string(m[0x1400].Bytes()); v != "last" {
+		t.Fatalf("unexpected repeated TLV: want %q, have %q", "last", v)
+	}
+	// Decode into a map with TLVs already set.
+	other := TLVMap{}
+	other.Set(0x1500, "other")
+	if err := other.Decode(bytes.NewBuffer(data)); err != nil {
+		t.Fatal(err)
+	}
+	if len(other) != 16 || string(other[0x1400].Bytes()) != "last" {
+		t.Fatalf("unexpected TLVs: %#v", other)
+	}
+	// Truncated TLV.
+	if _, err := DecodeTLVMap(bytes.NewBuffer(data[:len(data)-1])); err == nil {
+		t.Fatal("unexpected decode of truncated TLV")
+	}
+}
@@ -6,6 +6,7 @@ package pdufield
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 	"strconv"
 )
@@ -24,6 +25,9 @@ const (
 	DestinationAddr      Name = "destination_addr"
 	DestinationList      Name = "dest_addresses"
 	ESMClass             Name = "esm_class"
+	ESMEAddr             Name = "esme_addr"
+	ESMEAddrNPI          Name = "esme_addr_npi"
+	ESMEAddrTON          Name = "esme_addr_ton"
 	ErrorCode            Name = "error_code"
 	FinalDate            Name = "final_date"
 	InterfaceVersion     Name = "interface_version"
@@ -335,7 +339,7 @@ type UDH struct {
 
 // Len implements the Data interface.
 func (udh *UDH) Len() int {
-	return udh.IEI.Len() + udh.IELength.Len() + udh.IEData.Len()
+	return udh.IEI.Len() + udh.IELength.Len() + len(udh.IEData.Data)
 }
 
 // Raw implements the Data interface.
@@ -353,7 +357,8 @@ func (udh *UDH) Bytes() []byte {
 	var ret []byte
 	ret = append(ret, udh.IEI.Bytes()...)
 	ret = append(ret, udh.IELength.Bytes()...)
-	ret = append(ret, udh.IEData.Bytes()...)
+	// IEData is not a C-Octet String, unlike other Variable fields.
+	ret = append(ret, udh.IEData.Data...)
 	return ret
 }
 
@@ -405,3 +410,57 @@ func (udhl *UDHList) SerializeTo(w io.Writer) error {
 	_, err := w.Write(udhl.Bytes())
 	return err
 }
+
+// Concat returns the concatenation info of the UDH: the reference
+// number, the total number of parts and the number of this part,
+// starting at 1. Both the 8-bit (IEI 0x00) and 16-bit (IEI 0x08)
+// reference numbers are supported. It returns false when no valid
+// concatenation element is present.
+func (udhl *UDHList) Concat() (ref, total, part int, ok bool) {
+	for _, udh := range udhl.Data {
+		d := udh.IEData.Data
+		switch {
+		case udh.IEI.Data == 0x00 && len(d) == 3:
+			ref, total, part = int(d[0]), int(d[1]), int(d[2])
+		case udh.IEI.Data == 0x08 && len(d) == 4:
+			ref, total, part = int(binary.BigEndian.Uint16(d)), int(d[2]), int(d[3])
+		default:
+			continue
+		}
+		if part < 1 || part > total {
+			return 0, 0, 0, false
+		}
+		return ref, total, part, true
+	}
+	return 0, 0, 0, false
+}
+
+// ErrInvalidUDH is returned by SplitUDH when the header is truncated.
+var ErrInvalidUDH = errors.New("invalid user data header")
+
+// SplitUDH splits the user data header off b, e.g. the message_payload
+// TLV of a PDU with the UDHI flag set in esm_class. It returns the
+// parsed header and the remaining user data.
+//
+// The short_message field is split when the PDU is decoded, its user
+// data header is available in the GSMUserData field.
+func SplitUDH(b []byte) (*UDHList, []byte, error) {
+	if len(b) < 1 || len(b) < int(b[0])+1 {
+		return nil, nil, ErrInvalidUDH
+	}
+	h, ud := b[1:b[0]+1], b[b[0]+1:]
+	udhl := &UDHList{}
+	for len(h) > 0 {
+		if len(h) < 2 || len(h) < int(h[1])+2 {
+			return nil, nil, ErrInvalidUDH
+		}
+		l := int(h[1])
+		udhl.Data = append(udhl.Data, UDH{
+			IEI:      Fixed{Data: h[0]},
+			IELength: Fixed{Data: h[1]},
+			IEData:   Variable{Data: h[2 : l+2]},
+		})
+		h = h[l+2:]
+	}
+	return udhl, ud, nil
+}
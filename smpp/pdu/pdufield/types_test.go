@@ -235,3 +235,32 @@ func TestUnSmeList(t *testing.T) {
 		t.Fatalf("unexpected serialized bytes: want %q, have %q", bytesRep, v)
 	}
 }
+
+func TestSplitUDH(t *testing.T) {
+	b := []byte{0x06, 0x08, 0x04, 0x01, 0x02, 0x03, 0x02, 'h', 'i'}
+	udhl, ud, err := SplitUDH(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ud) != "hi" {
+		t.Fatalf("unexpected user data: want %q, have %q", "hi", ud)
+	}
+	if len(udhl.Data) != 1 || udhl.Data[0].IEI.Data != 0x08 || !bytes.Equal(b[3:7], udhl.Data[0].IEData.Data) {
+		t.Fatalf("unexpected udh: %#v", udhl.Data)
+	}
+	if have := append([]byte{byte(udhl.Len())}, udhl.Bytes()...); !bytes.Equal(b[:7], have) {
+		t.Fatalf("unexpected udh bytes: want %#x, have %#x", b[:7], have)
+	}
+	if have := New(GSMUserData, udhl.Bytes()).Bytes(); !bytes.Equal(b[1:7], have) {
+		t.Fatalf("unexpected udh bytes of new field: want %#x, have %#x", b[1:7], have)
+	}
+	ref, total, part, ok := udhl.Concat()
+	if !ok || ref != 0x0102 || total != 3 || part != 2 {
+		t.Fatalf("unexpected concat: want 258,3,2, have %d,%d,%d (%t)", ref, total, part, ok)
+	}
+	for _, b := range [][]byte{{}, {0x06, 0x08, 0x04}, {0x03, 0x00, 0x03, 0x01}} {
+		if _, _, err := SplitUDH(b); err != ErrInvalidUDH {
+			t.Fatalf("unexpected error for %q: want ErrInvalidUDH, have %v", b, err)
+		}
+	}
+}
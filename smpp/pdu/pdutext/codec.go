@@ -4,12 +4,19 @@
 
 package pdutext
 
+import (
+	"errors"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
 // DataCoding to define text codecs.
 type DataCoding uint8
 
 // Supported text codecs.
 const (
-	//	DefaultType   DataCoding = 0x00 // SMSC Default Alphabet
+	DefaultType DataCoding = 0x00 // SMSC Default Alphabet
 	//	IA5Type       DataCoding = 0x01 // IA5 (CCITT T.50)/ASCII (ANSI X3.4)
 	//	BinaryType    DataCoding = 0x02 // Octet unspecified (8-bit binary)
 	Latin1Type DataCoding = 0x03 // Latin 1 (ISO-8859-1)
@@ -24,6 +31,22 @@ const (
 	//	KSC5601Type   DataCoding = 0x0E // KS C 5601
 )
 
+// Compressed reports whether coding is a GSM 03.38 general data coding
+// scheme, 0x00 to 0x7F, with the text compression bit set, 0x20.
+// SMSCs that use the SMPP values of data_coding never set it.
+func (coding DataCoding) Compressed() bool {
+	return coding&0x80 == 0 && coding&0x20 != 0
+}
+
+// ErrCompressed is returned by DecodeText for compressed text, when
+// no Decompressor is given.
+var ErrCompressed = errors.New("pdutext: compressed text not supported")
+
+// Decompressor decompresses text for DecodeText, returning the
+// uncompressed text and the data_coding of its alphabet. The GSM 03.38
+// text compression is not implemented by this package.
+type Decompressor func(coding DataCoding, text []byte) (DataCoding, []byte, error)
+
 // Codec defines a text codec.
 type Codec interface {
 	// Type returns the value for the data_coding PDU.
@@ -36,6 +59,64 @@ type Codec interface {
 	Decode() []byte
 }
 
+// EncodedLen returns the length of the text of c once encoded, in
+// octets, or in septets for GSM7, which are the same as its septets
+// are not packed. Codecs of this package compute it without encoding
+// the text, others are encoded unless they provide an EncodedLen
+// method as well.
+func EncodedLen(c Codec) int {
+	if l, ok := c.(interface {
+		EncodedLen() int
+	}); ok {
+		return l.EncodedLen()
+	}
+	return len(c.Encode())
+}
+
+// LossyRunes returns the number of runes of the text of c that its
+// encoding cannot represent, and are substituted or make the text be
+// left as is when encoded, e.g. to warn or to fall back to UCS2. Codecs
+// of this package report it, others are assumed lossless unless they
+// provide a LossyRunes method as well.
+func LossyRunes(c Codec) int {
+	if l, ok := c.(interface {
+		LossyRunes() int
+	}); ok {
+		return l.LossyRunes()
+	}
+	return 0
+}
+
+// charmapLossy returns the number of runes of s not representable in
+// cm.
+func charmapLossy(cm *charmap.Charmap, s []byte) int {
+	n := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		i += size
+		if _, ok := cm.EncodeRune(r); !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// charmapLen returns the length of s encoded with cm. Text that is
+// not representable in cm is left as is by the codecs, so is its
+// length.
+func charmapLen(cm *charmap.Charmap, s []byte) int {
+	n := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		i += size
+		if _, ok := cm.EncodeRune(r); !ok {
+			return len(s)
+		}
+		n++
+	}
+	return n
+}
+
 // Encode text.
 func Encode(typ DataCoding, text []byte) []byte {
 	switch typ {
@@ -63,3 +144,20 @@ func Decode(typ DataCoding, text []byte) []byte {
 		return text
 	}
 }
+
+// DecodeText is like Decode, but handles compressed text rather than
+// returning it as is: it is decompressed with d before being decoded,
+// or ErrCompressed is returned if d is nil.
+func DecodeText(typ DataCoding, text []byte, d Decompressor) ([]byte, error) {
+	if typ.Compressed() {
+		if d == nil {
+			return nil, ErrCompressed
+		}
+		var err error
+		typ, text, err = d(typ, text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return Decode(typ, text), nil
+}
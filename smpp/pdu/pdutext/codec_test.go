@@ -46,3 +46,77 @@ func TestDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodedLen(t *testing.T) {
+	text := []string{
+		"",
+		"Lorem ipsum",
+		"áéíóú moço",
+		string(iso88595UTF8Bytes),
+		"{price} ~ [5€] | ^_^ \\o/",
+		"😀 emoji ✓",
+		"invalid \xff utf-8",
+	}
+	for _, s := range text {
+		for _, c := range []Codec{Raw(s), GSM7(s), Latin1(s), ISO88595(s), UCS2(s)} {
+			if want, have := len(c.Encode()), EncodedLen(c); want != have {
+				t.Fatalf("unexpected len for %T(%q): want %d, have %d", c, s, want, have)
+			}
+		}
+	}
+}
+
+func TestLossyRunes(t *testing.T) {
+	test := []struct {
+		c    Codec
+		want int
+	}{
+		{Raw("✓ \xff"), 0},
+		{GSM7("{5€} ✓ ok"), 1},
+		{GSM7("invalid \xff utf-8"), 1},
+		{Latin1("áéíóú moço ✓"), 1},
+		{ISO88595(iso88595UTF8Bytes), 0},
+		{ISO88595("Привет €"), 1},
+		{UCS2("😀 emoji ✓"), 0},
+		{UCS2("invalid \xff utf-8"), 1},
+	}
+	for _, tc := range test {
+		if have := LossyRunes(tc.c); have != tc.want {
+			t.Fatalf("unexpected lossy runes for %T(%q): want %d, have %d", tc.c, tc.c, tc.want, have)
+		}
+	}
+}
+
+func TestDecodeTextCompressed(t *testing.T) {
+	// GSM 03.38 general data coding, compressed, UCS2 alphabet.
+	const compressedUCS2 = DataCoding(0x28)
+	if !compressedUCS2.Compressed() {
+		t.Fatalf("data coding %#x not compressed", compressedUCS2)
+	}
+	for _, typ := range []DataCoding{DefaultType, Latin1Type, UCS2Type, 0xF6} {
+		if typ.Compressed() {
+			t.Fatalf("unexpected compressed data coding: %#x", typ)
+		}
+	}
+	text := []byte{0x1F, 0x8B, 0x00, 0x01}
+	if _, err := DecodeText(compressedUCS2, text, nil); err != ErrCompressed {
+		t.Fatalf("unexpected error: want ErrCompressed, have %v", err)
+	}
+	d := func(typ DataCoding, b []byte) (DataCoding, []byte, error) {
+		if !bytes.Equal(b, text) {
+			t.Fatalf("unexpected compressed text: want %q, have %q", text, b)
+		}
+		return UCS2Type, []byte("\x00m\x00o\x00\xe7\x00o"), nil
+	}
+	have, err := DecodeText(compressedUCS2, text, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("moço"); !bytes.Equal(want, have) {
+		t.Fatalf("unexpected text: want %q, have %q", want, have)
+	}
+	have, err = DecodeText(Latin1Type, []byte("mo\xe7o"), nil)
+	if err != nil || string(have) != "moço" {
+		t.Fatalf("unexpected text: want %q, have %q (%v)", "moço", have, err)
+	}
+}
@@ -9,6 +9,16 @@
 //
 // pdutext supports Latin1 (0x03) and UCS2 (0x08).
 //
+// GSM7 is the GSM 03.38 default alphabet (0x00), unpacked. It is not
+// used by Encode and Decode for 0x00, which many SMSCs use for ASCII.
+//
+// Compressed text, flagged in the GSM 03.38 data coding scheme, is not
+// decompressed: Decode returns it as is, and DecodeText returns
+// ErrCompressed unless given a Decompressor.
+//
+// Runes an encoding cannot represent are substituted, or leave the
+// text as is, when encoded. LossyRunes reports how many there are.
+//
 // Latin1 encoding is Windows-1252 (CP1252) for now, not ISO-8859-1.
 // http://www.i18nqa.com/debug/table-iso8859-1-vs-windows-1252.html
 //
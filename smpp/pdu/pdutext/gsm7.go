@@ -0,0 +1,134 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package pdutext
+
+import "unicode/utf8"
+
+// GSM7 text codec, the GSM 03.38 default alphabet with its extension
+// table. Septets are not packed, each one is sent in a single octet,
+// as most SMSCs expect over SMPP. Runes not available in the alphabet
+// are encoded as '?'.
+type GSM7 []byte
+
+// gsm7Escape is the escape to the extension table.
+const gsm7Escape = 0x1B
+
+// gsm7Basic is the GSM 03.38 default alphabet. The escape at 0x1B
+// is never decoded on its own.
+var gsm7Basic = [128]rune{
+	'@', '£', '$', '¥', 'è', 'é', 'ù', 'ì', 'ò', 'Ç', '\n', 'Ø', 'ø', '\r', 'Å', 'å',
+	'Δ', '_', 'Φ', 'Γ', 'Λ', 'Ω', 'Π', 'Ψ', 'Σ', 'Θ', 'Ξ', ' ', 'Æ', 'æ', 'ß', 'É',
+	' ', '!', '"', '#', '¤', '%', '&', '\'', '(', ')', '*', '+', ',', '-', '.', '/',
+	'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', ':', ';', '<', '=', '>', '?',
+	'¡', 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H', 'I', 'J', 'K', 'L', 'M', 'N', 'O',
+	'P', 'Q', 'R', 'S', 'T', 'U', 'V', 'W', 'X', 'Y', 'Z', 'Ä', 'Ö', 'Ñ', 'Ü', '§',
+	'¿', 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o',
+	'p', 'q', 'r', 's', 't', 'u', 'v', 'w', 'x', 'y', 'z', 'ä', 'ö', 'ñ', 'ü', 'à',
+}
+
+// gsm7Ext is the GSM 03.38 extension table, escaped by 0x1B.
+var gsm7Ext = map[byte]rune{
+	0x0A: '\f',
+	0x14: '^',
+	0x28: '{',
+	0x29: '}',
+	0x2F: '\\',
+	0x3C: '[',
+	0x3D: '~',
+	0x3E: ']',
+	0x40: '|',
+	0x65: '€',
+}
+
+var (
+	gsm7BasicRev = make(map[rune]byte)
+	gsm7ExtRev   = make(map[rune]byte)
+)
+
+func init() {
+	for i, r := range gsm7Basic {
+		if i != gsm7Escape {
+			gsm7BasicRev[r] = byte(i)
+		}
+	}
+	for b, r := range gsm7Ext {
+		gsm7ExtRev[r] = b
+	}
+}
+
+// Type implements the Codec interface.
+func (s GSM7) Type() DataCoding {
+	return DefaultType
+}
+
+// Encode to GSM7.
+func (s GSM7) Encode() []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRune(s[i:])
+		i += n
+		if c, ok := gsm7BasicRev[r]; ok {
+			b = append(b, c)
+		} else if c, ok := gsm7ExtRev[r]; ok {
+			b = append(b, gsm7Escape, c)
+		} else {
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+// Decode from GSM7.
+func (s GSM7) Decode() []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i] & 0x7F
+		if c == gsm7Escape && i+1 < len(s) {
+			i++
+			c = s[i] & 0x7F
+			if r, ok := gsm7Ext[c]; ok {
+				b = append(b, string(r)...)
+				continue
+			}
+			// Unknown extension, fall back to the default alphabet.
+		}
+		b = append(b, string(gsm7Basic[c])...)
+	}
+	return b
+}
+
+// EncodedLen returns the length of the text encoded to GSM7 in septets,
+// without encoding it. As septets are not packed, it is the length in
+// octets as well. Characters of the extension table take two septets.
+func (s GSM7) EncodedLen() int {
+	n := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		i += size
+		if _, ok := gsm7BasicRev[r]; !ok {
+			if _, ok := gsm7ExtRev[r]; ok {
+				n++
+			}
+		}
+		n++
+	}
+	return n
+}
+
+// LossyRunes returns the number of runes of the text that are not in
+// the GSM7 alphabet, encoded as '?'.
+func (s GSM7) LossyRunes() int {
+	n := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		i += size
+		if _, ok := gsm7BasicRev[r]; !ok {
+			if _, ok := gsm7ExtRev[r]; !ok {
+				n++
+			}
+		}
+	}
+	return n
+}
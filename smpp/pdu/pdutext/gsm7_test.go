@@ -0,0 +1,36 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package pdutext
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGSM7Encoder(t *testing.T) {
+	want := []byte("\x00 \x15 \x1be \x1b< Ol\x7f ?")
+	text := []byte("@ Ω € [ Olà ✓")
+	s := GSM7(text)
+	if s.Type() != 0x00 {
+		t.Fatalf("Unexpected data type; want 0x00, have %d", s.Type())
+	}
+	have := s.Encode()
+	if !bytes.Equal(want, have) {
+		t.Fatalf("Unexpected text; want %q, have %q", want, have)
+	}
+}
+
+func TestGSM7Decoder(t *testing.T) {
+	want := []byte("@ Ω € [ Olà")
+	text := []byte("\x00 \x15 \x1be \x1b< Ol\x7f")
+	s := GSM7(text)
+	if s.Type() != 0x00 {
+		t.Fatalf("Unexpected data type; want 0x00, have %d", s.Type())
+	}
+	have := s.Decode()
+	if !bytes.Equal(want, have) {
+		t.Fatalf("Unexpected text; want %q, have %q", want, have)
+	}
+}
@@ -36,3 +36,15 @@ func (s ISO88595) Decode() []byte {
 	}
 	return es
 }
+
+// EncodedLen returns the length of the text encoded to ISO88595,
+// without encoding it.
+func (s ISO88595) EncodedLen() int {
+	return charmapLen(charmap.ISO8859_5, s)
+}
+
+// LossyRunes returns the number of runes of the text that are not in
+// ISO88595.
+func (s ISO88595) LossyRunes() int {
+	return charmapLossy(charmap.ISO8859_5, s)
+}
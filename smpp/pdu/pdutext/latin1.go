@@ -36,3 +36,15 @@ func (s Latin1) Decode() []byte {
 	}
 	return es
 }
+
+// EncodedLen returns the length of the text encoded to Latin1,
+// without encoding it.
+func (s Latin1) EncodedLen() int {
+	return charmapLen(charmap.Windows1252, s)
+}
+
+// LossyRunes returns the number of runes of the text that are not in
+// Latin1.
+func (s Latin1) LossyRunes() int {
+	return charmapLossy(charmap.Windows1252, s)
+}
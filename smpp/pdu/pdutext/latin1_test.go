@@ -34,3 +34,13 @@ func TestLatin1Decoder(t *testing.T) {
 		t.Fatalf("Unexpected text; want %q, have %q", want, have)
 	}
 }
+
+func TestLatin1LossyRunes(t *testing.T) {
+	s := Latin1("Olá ✓ mundão")
+	if n := LossyRunes(s); n != 1 {
+		t.Fatalf("Unexpected lossy runes; want 1, have %d", n)
+	}
+	if n := LossyRunes(Latin1("Olá mundão")); n != 0 {
+		t.Fatalf("Unexpected lossy runes; want 0, have %d", n)
+	}
+}
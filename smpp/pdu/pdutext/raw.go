@@ -21,3 +21,8 @@ func (s Raw) Encode() []byte {
 func (s Raw) Decode() []byte {
 	return s
 }
+
+// EncodedLen returns the length of the encoded raw text.
+func (s Raw) EncodedLen() int {
+	return len(s)
+}
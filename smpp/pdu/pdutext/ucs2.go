@@ -5,6 +5,8 @@
 package pdutext
 
 import (
+	"unicode/utf8"
+
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 )
@@ -36,3 +38,33 @@ func (s UCS2) Decode() []byte {
 	}
 	return es
 }
+
+// EncodedLen returns the length of the text encoded to UCS2,
+// without encoding it.
+func (s UCS2) EncodedLen() int {
+	n := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		i += size
+		if r > 0xFFFF {
+			n += 4 // surrogate pair
+		} else {
+			n += 2
+		}
+	}
+	return n
+}
+
+// LossyRunes returns the number of invalid UTF-8 sequences of the
+// text, encoded as U+FFFD. Every valid rune is representable.
+func (s UCS2) LossyRunes() int {
+	n := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		i += size
+		if r == utf8.RuneError && size == 1 {
+			n++
+		}
+	}
+	return n
+}
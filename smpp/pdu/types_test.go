@@ -82,3 +82,83 @@ func TestBindResp(t *testing.T) {
 	t.Log(tx)
 }
 */
+
+func TestUserResponseCode(t *testing.T) {
+	for _, p := range []Body{NewSubmitSM(), NewDeliverSM()} {
+		f := p.Fields()
+		f.Set(pdufield.SourceAddr, "root")
+		f.Set(pdufield.DestinationAddr, "foobar")
+		f.Set(pdufield.ShortMessage, "hello")
+		p.TLVFields().SetUserResponseCode(0x2A)
+		var b bytes.Buffer
+		if err := p.SerializeTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		if l := uint32(b.Len()); l != p.Header().Len {
+			t.Fatalf("unexpected len for %s: want %d, have %d",
+				p.Header().ID, l, p.Header().Len)
+		}
+		r, err := Decode(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, ok := r.TLVFields().UserResponseCode()
+		if !ok {
+			t.Fatalf("missing user_response_code for %s: %#v",
+				p.Header().ID, r.TLVFields())
+		}
+		if code != 0x2A {
+			t.Fatalf("unexpected user_response_code for %s: want 0x2a, have %#x",
+				p.Header().ID, code)
+		}
+		if sm := r.Fields()[pdufield.ShortMessage]; sm.String() != "hello" {
+			t.Fatalf("unexpected short_message for %s: want hello, have %q",
+				p.Header().ID, sm)
+		}
+	}
+}
+
+func TestAlertNotification(t *testing.T) {
+	test := []struct {
+		v    pdufield.MsAvailability
+		set  bool
+		want string
+	}{
+		{pdufield.MsAvailable, false, "Available"},
+		{pdufield.MsAvailable, true, "Available"},
+		{pdufield.MsDenied, true, "Denied"},
+		{pdufield.MsUnavailable, true, "Unavailable"},
+		{pdufield.MsAvailability(0x07), true, "Reserved (7)"},
+	}
+	for _, el := range test {
+		p := NewAlertNotification()
+		f := p.Fields()
+		f.Set(pdufield.SourceAddrTON, 1)
+		f.Set(pdufield.SourceAddr, "5551234")
+		f.Set(pdufield.ESMEAddr, "root")
+		if el.set {
+			p.TLVFields().SetMsAvailabilityStatus(el.v)
+		}
+		var b bytes.Buffer
+		if err := p.SerializeTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		r, err := Decode(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := r.Header().ID; id != AlertNotificationID {
+			t.Fatalf("unexpected ID: want AlertNotification, have %s", id)
+		}
+		if addr := r.Fields()[pdufield.ESMEAddr]; addr.String() != "root" {
+			t.Fatalf("unexpected esme_addr: want root, have %q", addr)
+		}
+		s := r.TLVFields().MsAvailabilityStatus()
+		if s != el.v {
+			t.Fatalf("unexpected ms_availability_status: want %d, have %d", el.v, s)
+		}
+		if s.String() != el.want {
+			t.Fatalf("unexpected status text: want %q, have %q", el.want, s)
+		}
+	}
+}
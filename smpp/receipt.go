@@ -0,0 +1,308 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+)
+
+// ErrNotReceipt is returned by ParseDeliveryReceipt when the given
+// text is not a delivery receipt.
+var ErrNotReceipt = errors.New("not a delivery receipt")
+
+// DeliveryReceipt contains the fields of a delivery receipt, as sent
+// by most SMSCs in the short_message of deliver_sm. The format is
+// SMSC specific, see appendix B of the SMPP 3.4 spec:
+//
+//	id:IIIIIIIIII sub:SSS dlvrd:DDD submit date:YYMMDDhhmm done date:YYMMDDhhmm stat:DDDDDDD err:E text:...
+type DeliveryReceipt struct {
+	ID    string
+	Sub   string
+	Dlvrd string
+	Stat  string
+	Err   string
+	Text  string
+
+	// Dates as parsed, or zero if the date is absent or in an
+	// unknown layout. The original text is always available in
+	// RawSubmitDate and RawDoneDate.
+	SubmitDate    time.Time
+	DoneDate      time.Time
+	RawSubmitDate string
+	RawDoneDate   string
+}
+
+// receiptDateLayouts are the date layouts seen in delivery receipts,
+// indexed by length.
+var receiptDateLayouts = map[int]string{
+	10: "0601021504",
+	12: "060102150405",
+	14: "20060102150405",
+}
+
+// parseReceiptDate parses the date of a delivery receipt, in UTC.
+func parseReceiptDate(s string) time.Time {
+	layout, ok := receiptDateLayouts[len(s)]
+	if !ok {
+		return time.Time{}
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// receiptKeys are the keys of a delivery receipt, except text.
+var receiptKeys = []string{
+	"id:",
+	"sub:",
+	"dlvrd:",
+	"submit date:",
+	"done date:",
+	"stat:",
+	"err:",
+}
+
+// indexReceiptKey returns the index of key in s, only matching at the
+// beginning of s or after a space, or -1 if key is not present.
+func indexReceiptKey(s, key string) int {
+	for i := 0; i < len(s); {
+		n := strings.Index(s[i:], key)
+		if n < 0 {
+			return -1
+		}
+		n += i
+		if n == 0 || s[n-1] == ' ' {
+			return n
+		}
+		i = n + 1
+	}
+	return -1
+}
+
+// ParseDeliveryReceipt parses the text of a delivery receipt. Keys
+// are case insensitive and may appear in any order, text must be
+// the last one. Dates are accepted as YYMMDDhhmm, YYMMDDhhmmss or
+// YYYYMMDDhhmmss.
+func ParseDeliveryReceipt(text string) (*DeliveryReceipt, error) {
+	lower := strings.ToLower(text)
+	dr := &DeliveryReceipt{}
+	if i := indexReceiptKey(lower, "text:"); i >= 0 {
+		dr.Text = text[i+len("text:"):]
+		text, lower = text[:i], lower[:i]
+	}
+	type keyPos struct {
+		key   string
+		start int
+	}
+	var keys []keyPos
+	for _, k := range receiptKeys {
+		if i := indexReceiptKey(lower, k); i >= 0 {
+			keys = append(keys, keyPos{key: k, start: i})
+		}
+	}
+	if len(keys) == 0 || keys[0].key != "id:" {
+		return nil, ErrNotReceipt
+	}
+	// Sort by position, there's only a handful of keys.
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j].start < keys[j-1].start; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+	for i, k := range keys {
+		end := len(text)
+		if i+1 < len(keys) {
+			end = keys[i+1].start
+		}
+		v := strings.TrimSpace(text[k.start+len(k.key) : end])
+		switch k.key {
+		case "id:":
+			dr.ID = v
+		case "sub:":
+			dr.Sub = v
+		case "dlvrd:":
+			dr.Dlvrd = v
+		case "submit date:":
+			dr.RawSubmitDate = v
+			dr.SubmitDate = parseReceiptDate(v)
+		case "done date:":
+			dr.RawDoneDate = v
+			dr.DoneDate = parseReceiptDate(v)
+		case "stat:":
+			dr.Stat = v
+		case "err:":
+			dr.Err = v
+		}
+	}
+	return dr, nil
+}
+
+// ParseDeliveryReceipts parses text carrying several delivery
+// receipts, one per line, as batched by some SMSCs in the
+// message_payload of a single deliver_sm. Empty lines and lines that
+// are not delivery receipts are skipped. It returns ErrNotReceipt if
+// no line is a delivery receipt.
+func ParseDeliveryReceipts(text string) ([]*DeliveryReceipt, error) {
+	var receipts []*DeliveryReceipt
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if dr, err := ParseDeliveryReceipt(line); err == nil {
+			receipts = append(receipts, dr)
+		}
+	}
+	if len(receipts) == 0 {
+		return nil, ErrNotReceipt
+	}
+	return receipts, nil
+}
+
+// BatchedReceiptHandler returns a HandlerFunc that splits delivery
+// receipts batched in a single deliver_sm or data_sm, calling f once
+// per receipt. Other PDUs, and those that carry no delivery receipt,
+// are passed to next, if not nil.
+//
+// This is carrier specific: only use it with SMSCs known to batch
+// receipts, since the text of a regular receipt may span lines.
+func BatchedReceiptHandler(f func(p pdu.Body, dr *DeliveryReceipt), next HandlerFunc) HandlerFunc {
+	return func(p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.DeliverSMID, pdu.DataSMID:
+			if receipts, err := ParseDeliveryReceipts(receiptText(p)); err == nil {
+				for _, dr := range receipts {
+					f(p, dr)
+				}
+				return
+			}
+		}
+		if next != nil {
+			next(p)
+		}
+	}
+}
+
+// isReceipt reports whether the esm_class of p marks it as a delivery
+// receipt, a delivery or user acknowledgement, or an intermediate
+// delivery notification.
+func isReceipt(p pdu.Body) bool {
+	esm, ok := p.Fields()[pdufield.ESMClass]
+	if !ok {
+		return false
+	}
+	switch esm.Bytes()[0] & 0x3C {
+	case 0x04, 0x08, 0x10, 0x20:
+		return true
+	}
+	return false
+}
+
+// replaceReceiptID returns text, a delivery receipt, with its id
+// rewritten by f, and whether text is a delivery receipt.
+func replaceReceiptID(text string, f func(id string) string) (string, bool) {
+	dr, err := ParseDeliveryReceipt(text)
+	if err != nil || dr.ID == "" {
+		return text, false
+	}
+	i := indexReceiptKey(strings.ToLower(text), "id:") + len("id:")
+	n := strings.Index(text[i:], dr.ID)
+	if n < 0 {
+		return text, false
+	}
+	i += n
+	return text[:i] + f(dr.ID) + text[i+len(dr.ID):], true
+}
+
+// receiptText returns the text of the delivery receipt carried by p,
+// from short_message or else from message_payload.
+func receiptText(p pdu.Body) string {
+	if sm, ok := p.Fields()[pdufield.ShortMessage]; ok && sm.Len() > 0 {
+		return sm.String()
+	}
+	if mp, ok := p.TLVFields()[pdufield.MessagePayload]; ok {
+		return string(mp.Bytes())
+	}
+	return ""
+}
+
+// receiptStats maps the stat of delivery receipts to message states.
+var receiptStats = map[string]pdufield.State{
+	"ENROUTE": pdufield.StateEnroute,
+	"DELIVRD": pdufield.StateDelivered,
+	"EXPIRED": pdufield.StateExpired,
+	"DELETED": pdufield.StateDeleted,
+	"UNDELIV": pdufield.StateUndeliverable,
+	"ACCEPTD": pdufield.StateAccepted,
+	"UNKNOWN": pdufield.StateUnknown,
+	"REJECTD": pdufield.StateRejected,
+}
+
+// DeliveryReport is the outcome of a short message as reported by
+// the SMSC in deliver_sm or data_sm, combining the TLVs and the text
+// of the delivery receipt. Where both are present the TLVs take
+// precedence: receipted_message_id over id, and message_state over
+// stat.
+type DeliveryReport struct {
+	MessageID string         // receipted_message_id, or id of the receipt.
+	State     pdufield.State // message_state, or stat of the receipt; zero if neither is known.
+
+	// Only set when the respective TLV is present.
+	NetworkError          *pdufield.NetworkError // network_error_code.
+	DeliveryFailureReason *uint8                 // delivery_failure_reason.
+	DpfResult             *uint8                 // dpf_result, 1 if delivery pending flag was set.
+
+	// Receipt is the parsed text of short_message, or message_payload,
+	// or nil if it is not a delivery receipt.
+	Receipt *DeliveryReceipt
+}
+
+// ParseDeliveryReport returns the delivery report carried by p, a
+// deliver_sm or data_sm. It returns ErrNotReceipt if p carries
+// neither the receipted_message_id TLV nor a delivery receipt text.
+// Receipts with no stat in their text get State from message_state.
+func ParseDeliveryReport(p pdu.Body) (*DeliveryReport, error) {
+	dr := &DeliveryReport{}
+	if r, err := ParseDeliveryReceipt(receiptText(p)); err == nil {
+		dr.Receipt = r
+		dr.MessageID = r.ID
+		dr.State = receiptStats[strings.ToUpper(r.Stat)]
+	}
+	tlv := p.TLVFields()
+	if id, ok := tlv[pdufield.ReceiptedMessageID]; ok {
+		// C-Octet String, drop the terminator if present.
+		dr.MessageID = strings.TrimRight(string(id.Bytes()), "\x00")
+	}
+	if dr.Receipt == nil && dr.MessageID == "" {
+		return nil, ErrNotReceipt
+	}
+	if state, ok := tlv.MessageState(); ok {
+		dr.State = state
+	}
+	dr.NetworkError, dr.DeliveryFailureReason, dr.DpfResult = deliveryDetails(tlv)
+	return dr, nil
+}
+
+// deliveryDetails returns the network_error_code, delivery_failure_reason
+// and dpf_result TLVs of tlv, or nil for those not present.
+func deliveryDetails(tlv pdufield.TLVMap) (ne *pdufield.NetworkError, reason, dpf *uint8) {
+	if v, ok := tlv.NetworkErrorCode(); ok {
+		ne = &v
+	}
+	if v, ok := tlv[pdufield.DeliveryFailureReason]; ok && v.Len == 1 {
+		reason = &v.Bytes()[0]
+	}
+	if v, ok := tlv[pdufield.DpfResult]; ok && v.Len == 1 {
+		dpf = &v.Bytes()[0]
+	}
+	return ne, reason, dpf
+}
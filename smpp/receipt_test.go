@@ -0,0 +1,190 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+)
+
+func TestParseDeliveryReceipt(t *testing.T) {
+	test := []struct {
+		text       string
+		submitDate time.Time
+		doneDate   time.Time
+		raw        string
+	}{
+		{
+			text:       "id:1234567890 sub:001 dlvrd:001 submit date:1609301415 done date:1609301416 stat:DELIVRD err:000 text:Hello world",
+			submitDate: time.Date(2016, 9, 30, 14, 15, 0, 0, time.UTC),
+			doneDate:   time.Date(2016, 9, 30, 14, 16, 0, 0, time.UTC),
+			raw:        "1609301415",
+		},
+		{
+			text:       "id:1234567890 sub:001 dlvrd:001 submit date:20160930141503 done date:20160930141659 stat:DELIVRD err:000 text:Hello world",
+			submitDate: time.Date(2016, 9, 30, 14, 15, 3, 0, time.UTC),
+			doneDate:   time.Date(2016, 9, 30, 14, 16, 59, 0, time.UTC),
+			raw:        "20160930141503",
+		},
+		{
+			text: "id:1234567890 sub:001 dlvrd:001 submit date:30/09/2016 done date:30/09/2016 stat:DELIVRD err:000 text:Hello world",
+			raw:  "30/09/2016",
+		},
+	}
+	for _, el := range test {
+		dr, err := ParseDeliveryReceipt(el.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dr.ID != "1234567890" {
+			t.Fatalf("unexpected id: want 1234567890, have %q", dr.ID)
+		}
+		if dr.Sub != "001" || dr.Dlvrd != "001" || dr.Err != "000" {
+			t.Fatalf("unexpected sub/dlvrd/err: %#v", dr)
+		}
+		if dr.Stat != "DELIVRD" {
+			t.Fatalf("unexpected stat: want DELIVRD, have %q", dr.Stat)
+		}
+		if dr.Text != "Hello world" {
+			t.Fatalf("unexpected text: want %q, have %q", "Hello world", dr.Text)
+		}
+		if !dr.SubmitDate.Equal(el.submitDate) {
+			t.Fatalf("unexpected submit date: want %s, have %s", el.submitDate, dr.SubmitDate)
+		}
+		if !dr.DoneDate.Equal(el.doneDate) {
+			t.Fatalf("unexpected done date: want %s, have %s", el.doneDate, dr.DoneDate)
+		}
+		if dr.RawSubmitDate != el.raw {
+			t.Fatalf("unexpected raw submit date: want %q, have %q", el.raw, dr.RawSubmitDate)
+		}
+	}
+	if _, err := ParseDeliveryReceipt("Hello world"); err != ErrNotReceipt {
+		t.Fatalf("unexpected error: want ErrNotReceipt, have %v", err)
+	}
+}
+
+func TestParseDeliveryReport(t *testing.T) {
+	text := "id:1234567890 sub:001 dlvrd:000 submit date:1609301415 done date:1609301416 stat:UNDELIV err:001 text:Hello world"
+
+	// Text only.
+	p := pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.ShortMessage, text)
+	dr, err := ParseDeliveryReport(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.MessageID != "1234567890" || dr.State != pdufield.StateUndeliverable {
+		t.Fatalf("unexpected report: %#v", dr)
+	}
+	if dr.Receipt == nil || dr.Receipt.Err != "001" {
+		t.Fatalf("unexpected receipt: %#v", dr.Receipt)
+	}
+	if dr.NetworkError != nil || dr.DeliveryFailureReason != nil || dr.DpfResult != nil {
+		t.Fatalf("unexpected TLV values: %#v", dr)
+	}
+
+	// TLVs take precedence over text.
+	tlv := p.TLVFields()
+	tlv.Set(pdufield.ReceiptedMessageID, "abcdef\x00")
+	tlv.Set(pdufield.MessageStateOption, uint8(pdufield.StateDelivered))
+	tlv.Set(pdufield.NetworkErrorCode, []byte{0x03, 0x00, 0x22})
+	tlv.Set(pdufield.DeliveryFailureReason, uint8(1))
+	tlv.Set(pdufield.DpfResult, uint8(1))
+	dr, err = ParseDeliveryReport(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.MessageID != "abcdef" {
+		t.Fatalf("unexpected message id: want abcdef, have %q", dr.MessageID)
+	}
+	if dr.State != pdufield.StateDelivered {
+		t.Fatalf("unexpected state: want %s, have %s", pdufield.StateDelivered, dr.State)
+	}
+	if dr.Receipt == nil || dr.Receipt.ID != "1234567890" {
+		t.Fatalf("unexpected receipt: %#v", dr.Receipt)
+	}
+	if ne := dr.NetworkError; ne == nil || ne.Type != 3 || ne.Code != 0x22 {
+		t.Fatalf("unexpected network error: %#v", ne)
+	}
+	if dr.DeliveryFailureReason == nil || *dr.DeliveryFailureReason != 1 {
+		t.Fatalf("unexpected delivery failure reason: %#v", dr.DeliveryFailureReason)
+	}
+	if dr.DpfResult == nil || *dr.DpfResult != 1 {
+		t.Fatalf("unexpected dpf result: %#v", dr.DpfResult)
+	}
+
+	// TLVs only.
+	p.Fields().Set(pdufield.ShortMessage, "")
+	dr, err = ParseDeliveryReport(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.MessageID != "abcdef" || dr.State != pdufield.StateDelivered || dr.Receipt != nil {
+		t.Fatalf("unexpected report: %#v", dr)
+	}
+
+	// Text without stat, state from message_state.
+	p = pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.ShortMessage, "id:1234567890 sub:001 dlvrd:001 submit date:1609301415 done date:1609301416 err:000 text:Hello world")
+	p.TLVFields().Set(pdufield.MessageStateOption, uint8(pdufield.StateExpired))
+	dr, err = ParseDeliveryReport(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.MessageID != "1234567890" || dr.State != pdufield.StateExpired {
+		t.Fatalf("unexpected report: %#v", dr)
+	}
+	if dr.Receipt == nil || dr.Receipt.Stat != "" {
+		t.Fatalf("unexpected receipt: %#v", dr.Receipt)
+	}
+
+	// Neither.
+	p = pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.ShortMessage, "Hello world")
+	if _, err := ParseDeliveryReport(p); err != ErrNotReceipt {
+		t.Fatalf("unexpected error: want ErrNotReceipt, have %v", err)
+	}
+}
+
+func TestBatchedReceiptHandler(t *testing.T) {
+	payload := "id:1 sub:001 dlvrd:001 submit date:1609301415 done date:1609301416 stat:DELIVRD err:000 text:one\n" +
+		"id:2 sub:001 dlvrd:000 submit date:1609301415 done date:1609301416 stat:UNDELIV err:001 text:two\r\n" +
+		"\n" +
+		"id:3 sub:001 dlvrd:000 submit date:1609301415 done date:1609301416 stat:EXPIRED err:002 text:three\n"
+	p := pdu.NewDeliverSM()
+	p.TLVFields().Set(pdufield.MessagePayload, payload)
+	var have []*DeliveryReceipt
+	var other int
+	h := BatchedReceiptHandler(func(p pdu.Body, dr *DeliveryReceipt) {
+		have = append(have, dr)
+	}, func(p pdu.Body) { other++ })
+	h(p)
+	want := []struct{ id, stat, text string }{
+		{"1", "DELIVRD", "one"},
+		{"2", "UNDELIV", "two"},
+		{"3", "EXPIRED", "three"},
+	}
+	if len(have) != len(want) {
+		t.Fatalf("unexpected number of receipts: want %d, have %d", len(want), len(have))
+	}
+	for i, w := range want {
+		dr := have[i]
+		if dr.ID != w.id || dr.Stat != w.stat || dr.Text != w.text {
+			t.Fatalf("unexpected receipt %d: want %v, have %#v", i, w, dr)
+		}
+	}
+	if other != 0 {
+		t.Fatalf("unexpected calls to next handler: %d", other)
+	}
+	p = pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.ShortMessage, "Hello world")
+	h(p)
+	if other != 1 || len(have) != 3 {
+		t.Fatalf("unexpected dispatch of non-receipt: next %d, receipts %d", other, len(have))
+	}
+}
@@ -6,28 +6,48 @@ package smpp
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/fiorix/go-smpp/smpp/pdu"
 	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
 )
 
 // Receiver implements an SMPP client receiver.
 type Receiver struct {
 	Addr                 string
+	Network              string // Network of Addr, tcp or unix for a socket path, default tcp.
 	User                 string
 	Passwd               string
 	SystemType           string
 	EnquireLink          time.Duration
 	EnquireLinkTimeout   time.Duration // Time after last EnquireLink response when connection considered down
+	CoalesceEnquireLink  bool          // Send EnquireLink only when no PDU was sent for EnquireLink, responses count for EnquireLinkTimeout meanwhile, optional.
+	AnyPDUKeepAlive      bool          // Count any received PDU, not only EnquireLinkResp, for EnquireLinkTimeout, optional.
+	DialTimeout          time.Duration // Timeout for connecting to the server, optional.
+	LocalAddr            net.Addr      // Local address to connect from, optional.
+	BindTimeout          time.Duration // Timeout for the bind response, optional.
+	WriteTimeout         time.Duration // Timeout for writing a PDU, after which the connection is closed, optional.
 	BindInterval         time.Duration // Binding retry interval
+	AlreadyBoundInterval time.Duration // Binding retry interval after ESME_RALYBND, optional.
+	BindLimit            *BindLimit    // Limit of concurrent sessions, shared with the clients given the same one, optional.
 	MergeInterval        time.Duration // Time in which Receiver waits for the parts of the long messages
 	MergeCleanupInterval time.Duration // How often to cleanup expired message parts
+	MergeAcrossRebind    bool          // Keep the parts waiting for merge on rebind, until MergeInterval, optional.
+	NoHandlerBuffer      int           // Number of PDUs kept while Handler is not set, see SetHandler; others are rejected, optional.
 	TLS                  *tls.Config
-	Handler              HandlerFunc
+	BindTracer           func(bt *BindTrace)      // Called with the details of each bind attempt, optional.
+	Handler              HandlerFunc              // Handler of inbound PDUs, see SetHandler to change it after Bind.
+	ShortMessageFilter   func(b []byte) []byte    // Rewrites inbound short_message before Handler, optional.
+	DataCodings          []pdutext.DataCoding     // Accepted data_coding of inbound PDUs, any if empty, optional.
+	SMLengthPolicy       *pdufield.SMLengthPolicy // How to decode inbound PDUs whose sm_length disagrees with the short message, optional.
+	MessageIDNormalizer  func(id string) string   // Rewrites message_id of delivery receipts to a canonical form, optional.
+	RawHandler           HandlerFunc              // Handler of inbound PDUs with other data_coding, delivered as received, optional.
 	SkipAutoRespondIDs   []pdu.ID
 
 	chanClose chan struct{}
@@ -43,12 +63,57 @@ type Receiver struct {
 		*client
 		sync.Mutex
 	}
+
+	// Handler in use, and the PDUs kept while it is not set or while
+	// SetHandler passes it the kept ones. Once SetHandler is called,
+	// Handler is no longer read.
+	h struct {
+		sync.Mutex
+		f        HandlerFunc
+		buf      []pdu.Body
+		draining bool
+		set      bool
+	}
 }
 
 // HandlerFunc is the handler function that a Receiver calls
 // when a new PDU arrives.
 type HandlerFunc func(p pdu.Body)
 
+// routeRaw returns a HandlerFunc that passes PDUs with data_coding
+// not in dcs to raw, if not nil, and other PDUs to h.
+func routeRaw(h, raw HandlerFunc, dcs []pdutext.DataCoding) HandlerFunc {
+	if raw == nil {
+		return h
+	}
+	return func(p pdu.Body) {
+		if !acceptDataCoding(p, dcs) {
+			raw(p)
+		} else if h != nil {
+			h(p)
+		}
+	}
+}
+
+// OriginalShortMessage returns the short_message of p as received,
+// before ShortMessageFilter and merging, or nil if p was not received
+// or has none.
+func OriginalShortMessage(p pdu.Body) []byte {
+	raw := pdu.Raw(p)
+	if raw == nil {
+		return nil
+	}
+	op, err := pdu.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	sm, ok := op.Fields()[pdufield.ShortMessage]
+	if !ok {
+		return nil
+	}
+	return sm.Bytes()
+}
+
 // MergeHolder is a struct which holds the slice of MessageParts for the merging of a long incoming message.
 type MergeHolder struct {
 	MessageID     int
@@ -79,15 +144,33 @@ func (r *Receiver) Bind() <-chan ConnStatus {
 	}
 
 	c := &client{
-		Addr:               r.Addr,
-		TLS:                r.TLS,
-		EnquireLink:        r.EnquireLink,
-		EnquireLinkTimeout: r.EnquireLinkTimeout,
-		Status:             make(chan ConnStatus, 1),
-		BindFunc:           r.bindFunc,
-		BindInterval:       r.BindInterval,
+		Addr:                 r.Addr,
+		Network:              r.Network,
+		TLS:                  r.TLS,
+		EnquireLink:          r.EnquireLink,
+		EnquireLinkTimeout:   r.EnquireLinkTimeout,
+		CoalesceEnquireLink:  r.CoalesceEnquireLink,
+		AnyPDUKeepAlive:      r.AnyPDUKeepAlive,
+		Status:               make(chan ConnStatus, 1),
+		BindFunc:             r.bindFunc,
+		DialTimeout:          r.DialTimeout,
+		LocalAddr:            r.LocalAddr,
+		BindTimeout:          r.BindTimeout,
+		WriteTimeout:         r.WriteTimeout,
+		BindInterval:         r.BindInterval,
+		AlreadyBoundInterval: r.AlreadyBoundInterval,
+		BindLimit:            r.BindLimit,
+		BindTracer:           r.BindTracer,
+		ShortMessageFilter:   r.ShortMessageFilter,
+		DataCodings:          r.DataCodings,
+		SMLengthPolicy:       r.SMLengthPolicy,
+		MessageIDNormalizer:  r.MessageIDNormalizer,
 	}
 	r.cl.client = c
+	r.h.Lock()
+	r.h.f = r.Handler
+	r.h.set = false
+	r.h.Unlock()
 
 	c.init()
 	go c.Bind()
@@ -111,7 +194,7 @@ func (r *Receiver) bindFunc(c Conn) error {
 	f.Set(pdufield.SystemID, r.User)
 	f.Set(pdufield.Password, r.Passwd)
 	f.Set(pdufield.SystemType, r.SystemType)
-	resp, err := bind(c, p)
+	resp, err := bind(c, p, r.cl.BindTracer)
 	if err != nil {
 		return err
 	}
@@ -121,16 +204,15 @@ func (r *Receiver) bindFunc(c Conn) error {
 	}
 
 	// Clean the map in case of rebind, because message id numbering resets after reconnection
-	// and older IDs are no longer valid
-	if r.MergeInterval > 0 {
+	// and older IDs are no longer valid, unless the SMSC keeps them and the remaining parts
+	// of a long message may arrive on the new connection
+	if r.MergeInterval > 0 && !r.MergeAcrossRebind {
 		r.mg.Lock()
 		r.mg.mergeHolders = make(map[int]*MergeHolder)
 		r.mg.Unlock()
 	}
 
-	if r.Handler != nil {
-		go r.handlePDU()
-	}
+	go r.handlePDU()
 
 	return nil
 }
@@ -145,108 +227,227 @@ func idInList(id pdu.ID, list []pdu.ID) bool {
 }
 
 func (r *Receiver) handlePDU() {
-	var (
-		ok                bool
-		sm                *pdufield.SM
-		udhList           *pdufield.UDHList
-		msgID, partsCount int
-		mh                *MergeHolder
-		orderedBodies     []*bytes.Buffer
-	)
 	autoRespondDeliver := !idInList(pdu.DeliverSMID, r.SkipAutoRespondIDs)
+	autoRespondData := !idInList(pdu.DataSMID, r.SkipAutoRespondIDs)
 
-loop:
 	for {
 		p, err := r.cl.Read()
 		if err != nil {
 			break
 		}
 
+		r.h.Lock()
+		adopt := r.h.f == nil && !r.h.set
+		r.h.Unlock()
+		if adopt && r.Handler != nil {
+			// Handler assigned after Bind, without SetHandler.
+			r.setHandler(r.Handler, false)
+		}
+
+		r.h.Lock()
+		h := r.h.f
+		switch {
+		case h == nil && len(r.h.buf) >= r.NoHandlerBuffer: // Reject the PDU
+			r.h.Unlock()
+			r.reject(p)
+			continue
+		case h == nil || r.h.draining: // Keep the PDU for SetHandler
+			r.h.buf = append(r.h.buf, p)
+			h = nil
+		}
+		r.h.Unlock()
+
 		if p.Header().ID == pdu.DeliverSMID && autoRespondDeliver { // Send DeliverSMResp
 			pResp := pdu.NewDeliverSMRespSeq(p.Header().Seq)
 			r.cl.Write(pResp)
 		}
 
-		if r.MergeInterval == 0 { // Handle the PDU if merging is not needed
-			r.Handler(p)
-			continue
+		if p.Header().ID == pdu.DataSMID && autoRespondData { // Send DataSMResp
+			pResp := pdu.NewDataSMRespSeq(p.Header().Seq)
+			r.cl.Write(pResp)
 		}
 
-		sm, ok = p.Fields()[pdufield.ShortMessage].(*pdufield.SM)
-		if !ok {
-			// PDU is malformed, do not process
-			continue
+		if h != nil {
+			r.handle(h, p)
 		}
+	}
+}
 
-		udhList, ok = p.Fields()[pdufield.GSMUserData].(*pdufield.UDHList)
-		if !ok { // Check if GSMUserData is present inside the PDU, do not try to merge if it's not
-			r.Handler(p)
-			continue
+// reject responds to p, a deliver_sm or data_sm received while there
+// is no Handler, with a temporary error for the SMSC to retry later.
+// Other PDUs are dropped.
+func (r *Receiver) reject(p pdu.Body) {
+	var resp pdu.Body
+	switch p.Header().ID {
+	case pdu.DeliverSMID:
+		resp = pdu.NewDeliverSMRespSeq(p.Header().Seq)
+	case pdu.DataSMID:
+		resp = pdu.NewDataSMRespSeq(p.Header().Seq)
+	default:
+		return
+	}
+	resp.Header().Status = statusReceiverTempAppError
+	r.cl.Write(resp)
+}
+
+// SetHandler sets the Handler of a bound Receiver, first passing it the
+// PDUs kept while there was none, see NoHandlerBuffer. PDUs arriving
+// meanwhile are passed after these, in order. It may be called from
+// the Handler.
+//
+// Assigning Handler after Bind works too, until SetHandler is called,
+// but is only picked up on the next PDU and races with Receiver reading
+// it; prefer SetHandler.
+func (r *Receiver) SetHandler(h HandlerFunc) {
+	r.setHandler(h, true)
+}
+
+// setHandler implements SetHandler. Unless explicit, h is only taken
+// if there is no Handler and SetHandler was never called.
+func (r *Receiver) setHandler(h HandlerFunc, explicit bool) {
+	r.h.Lock()
+	if explicit {
+		r.h.set = true
+	} else if r.h.set || r.h.f != nil {
+		r.h.Unlock()
+		return
+	}
+	r.h.f = h
+	if h == nil || r.h.draining { // Already passing the kept PDUs, to r.h.f from now on
+		r.h.Unlock()
+		return
+	}
+	r.h.draining = true
+	for h != nil && len(r.h.buf) > 0 {
+		buf := r.h.buf
+		r.h.buf = nil
+		r.h.Unlock()
+		for _, p := range buf {
+			r.handle(h, p)
 		}
+		r.h.Lock()
+		h = r.h.f
+	}
+	r.h.draining = false
+	r.h.Unlock()
+}
 
-		for _, udh := range udhList.Data {
-			switch udh.IEI.Data {
-			case 0x00: // Concatenated short messages, 8-bit reference number
-				if int(udh.IELength.Data) != 3 { // Contains message ID, parts count and part number
-					// PDU is malformed, do not process
-					break
-				}
+// handle passes p to h, once all the parts of long messages arrive if
+// merging is enabled.
+func (r *Receiver) handle(h HandlerFunc, p pdu.Body) {
+	var (
+		ok                        bool
+		sm                        *pdufield.SM
+		udhList                   *pdufield.UDHList
+		msgID, partsCount, partID int
+		mh                        *MergeHolder
+		orderedBodies             []*bytes.Buffer
+	)
 
-				// Get message ID and total count of its parts
-				msgID = int(udh.IEData.Data[0])
-				partsCount = int(udh.IEData.Data[1])
+	if !acceptDataCoding(p, r.DataCodings) { // Deliver as received
+		if r.RawHandler != nil {
+			h = r.RawHandler
+		}
+		h(p)
+		return
+	}
 
-				// Check if message part was already added to a MergeHolder
-				r.mg.Lock()
-				if mh, ok = r.mg.mergeHolders[msgID]; !ok {
-					mh = &MergeHolder{
-						MessageID:  msgID,
-						PartsCount: partsCount,
-					}
+	if r.MergeInterval == 0 { // Handle the PDU if merging is not needed
+		h(p)
+		return
+	}
 
-					r.mg.mergeHolders[msgID] = mh
-				}
-				r.mg.Unlock()
-
-				// Add current part of the message to the slice
-				mh.MessageParts = append(mh.MessageParts, &MessagePart{
-					PartID: int(udh.IEData.Data[2]),
-					Data:   bytes.NewBuffer(sm.Data),
-				})
-				mh.LastWriteTime = time.Now()
-
-				// Check if we have all the parts of the message
-				if len(mh.MessageParts) != mh.PartsCount {
-					continue loop
-				}
+	if p.Header().ID == pdu.DataSMID { // data_sm carries message_payload, nothing to merge
+		h(p)
+		return
+	}
 
-				// Order up PDUs
-				orderedBodies = make([]*bytes.Buffer, partsCount)
-				for _, mp := range mh.MessageParts {
-					orderedBodies[mp.PartID-1] = mp.Data
-				}
+	sm, ok = p.Fields()[pdufield.ShortMessage].(*pdufield.SM)
+	if !ok {
+		// PDU is malformed, do not process
+		return
+	}
 
-				// Merge PDUs
-				var buf bytes.Buffer
-				for _, body := range orderedBodies {
-					buf.Write(body.Bytes())
-				}
+	udhList, ok = p.Fields()[pdufield.GSMUserData].(*pdufield.UDHList)
+	if !ok { // Check if GSMUserData is present inside the PDU, do not try to merge if it's not
+		h(p)
+		return
+	}
 
-				p.Fields().Set(pdufield.ShortMessage, buf.Bytes())
+	msgID, partsCount, partID, ok = udhList.Concat()
+	if !ok { // Not a part of a concatenated message, nothing to merge
+		h(p)
+		return
+	}
 
-				// Handle
-				r.Handler(p)
-			}
+	// Check if message part was already added to a MergeHolder
+	r.mg.Lock()
+	if mh, ok = r.mg.mergeHolders[msgID]; !ok {
+		mh = &MergeHolder{
+			MessageID:  msgID,
+			PartsCount: partsCount,
+		}
+
+		r.mg.mergeHolders[msgID] = mh
+	}
+
+	if partsCount != mh.PartsCount { // Part of another message with the same reference, drop it
+		r.mg.Unlock()
+		return
+	}
+
+	// Add current part of the message to the slice, replacing a
+	// part delivered again, e.g. by the SMSC after a rebind
+	mp := &MessagePart{
+		PartID: partID,
+		Data:   bytes.NewBuffer(sm.Data),
+	}
+	dup := false
+	for i, part := range mh.MessageParts {
+		if part.PartID == partID {
+			mh.MessageParts[i] = mp
+			dup = true
+			break
 		}
 	}
+	if !dup {
+		mh.MessageParts = append(mh.MessageParts, mp)
+	}
+	mh.LastWriteTime = time.Now()
+
+	// Check if we have all the parts of the message
+	if len(mh.MessageParts) != mh.PartsCount {
+		r.mg.Unlock()
+		return
+	}
+	delete(r.mg.mergeHolders, msgID)
+	r.mg.Unlock()
+
+	// Order up PDUs
+	orderedBodies = make([]*bytes.Buffer, mh.PartsCount)
+	for _, mp := range mh.MessageParts {
+		orderedBodies[mp.PartID-1] = mp.Data
+	}
+
+	// Merge PDUs
+	var buf bytes.Buffer
+	for _, body := range orderedBodies {
+		buf.Write(body.Bytes())
+	}
+
+	p.Fields().Set(pdufield.ShortMessage, buf.Bytes())
+
+	// Handle
+	h(p)
 }
 
 func (r *Receiver) mergeCleaner() {
-	timer := time.NewTimer(r.MergeCleanupInterval)
+	ticker := time.NewTicker(r.MergeCleanupInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-timer.C:
+		case <-ticker.C:
 			r.mg.Lock()
 			for _, mHolder := range r.mg.mergeHolders {
 				if time.Since(mHolder.LastWriteTime) > r.MergeInterval { // Message has expired, remove
@@ -261,6 +462,30 @@ func (r *Receiver) mergeCleaner() {
 	}
 }
 
+// Ping sends an EnquireLink and waits for its response, returning
+// the round-trip time, or ctx.Err() if ctx is done first.
+func (r *Receiver) Ping(ctx context.Context) (time.Duration, error) {
+	r.cl.Lock()
+	c := r.cl.client
+	r.cl.Unlock()
+	if c == nil {
+		return 0, ErrNotBound
+	}
+	return c.Ping(ctx)
+}
+
+// CongestionState returns the congestion_state last reported by the
+// SMSC in enquire_link_resp, from 0 (idle) to 100 (congested), and
+// whether one was reported on the current connection.
+func (r *Receiver) CongestionState() (uint8, bool) {
+	r.cl.Lock()
+	defer r.cl.Unlock()
+	if r.cl.client == nil {
+		return 0, false
+	}
+	return r.cl.congestionState()
+}
+
 // Close implements the ClientConn interface.
 func (r *Receiver) Close() error {
 	r.cl.Lock()
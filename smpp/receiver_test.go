@@ -5,10 +5,13 @@
 package smpp
 
 import (
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
 	"github.com/fiorix/go-smpp/smpp/smpptest"
 )
 
@@ -43,4 +46,516 @@ func TestReceiver(t *testing.T) {
 	case <-time.After(time.Second):
 		t.Fatal("timeout waiting for server to echo")
 	}
-}
\ No newline at end of file
+}
+func TestReceiverDataSM(t *testing.T) {
+	respc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		select {
+		case respc <- p:
+		default:
+		}
+	}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	r := &Receiver{
+		Addr:    s.Addr(),
+		User:    smpptest.DefaultUser,
+		Passwd:  smpptest.DefaultPasswd,
+		Handler: func(p pdu.Body) { rc <- p },
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	p := pdu.NewDataSM()
+	f := p.Fields()
+	f.Set(pdufield.SourceAddr, "5551234")
+	f.Set(pdufield.DestinationAddr, "root")
+	p.TLVFields().Set(pdufield.MessagePayload, "hello payload")
+	s.BroadcastMessage(p)
+	select {
+	case m := <-rc:
+		if m.Header().ID != pdu.DataSMID {
+			t.Fatalf("unexpected PDU: want DataSM, have %s", m.Header().ID)
+		}
+		if src := m.Fields()[pdufield.SourceAddr]; src.String() != "5551234" {
+			t.Fatalf("unexpected source_addr: want 5551234, have %q", src)
+		}
+		payload := m.TLVFields()[pdufield.MessagePayload]
+		if payload == nil || string(payload.Bytes()) != "hello payload" {
+			t.Fatalf("unexpected message_payload: %#v", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for data_sm")
+	}
+	select {
+	case resp := <-respc:
+		if resp.Header().ID != pdu.DataSMRespID {
+			t.Fatalf("unexpected resp: want DataSMResp, have %s", resp.Header().ID)
+		}
+		if resp.Header().Seq != p.Header().Seq {
+			t.Fatalf("unexpected resp seq: want %d, have %d", p.Header().Seq, resp.Header().Seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for data_sm_resp")
+	}
+}
+
+func TestReceiverUDH(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	r := &Receiver{
+		Addr:    s.Addr(),
+		User:    smpptest.DefaultUser,
+		Passwd:  smpptest.DefaultPasswd,
+		Handler: func(p pdu.Body) { rc <- p },
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	p := pdu.NewDeliverSM()
+	f := p.Fields()
+	f.Set(pdufield.SourceAddr, "5551234")
+	f.Set(pdufield.DestinationAddr, "root")
+	f.Set(pdufield.ESMClass, 0x40)
+	f.Set(pdufield.ShortMessage, []byte{0x06, 0x08, 0x04, 0x01, 0x02, 0x03, 0x02, 'w', 'o', 'r', 'l', 'd'})
+	s.BroadcastMessage(p)
+	select {
+	case m := <-rc:
+		sm := m.Fields()[pdufield.ShortMessage]
+		if text := sm.String(); text != "world" {
+			t.Fatalf("unexpected short_message: want %q, have %q", "world", text)
+		}
+		udhl, ok := m.Fields()[pdufield.GSMUserData].(*pdufield.UDHList)
+		if !ok {
+			t.Fatalf("missing udh: %#v", m.Fields())
+		}
+		ref, total, part, ok := udhl.Concat()
+		if !ok || ref != 0x0102 || total != 3 || part != 2 {
+			t.Fatalf("unexpected concat: want 258,3,2, have %d,%d,%d (%t)", ref, total, part, ok)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for deliver_sm")
+	}
+}
+
+func TestReceiverShortMessageFilter(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	r := &Receiver{
+		Addr:    s.Addr(),
+		User:    smpptest.DefaultUser,
+		Passwd:  smpptest.DefaultPasswd,
+		Handler: func(p pdu.Body) { rc <- p },
+		ShortMessageFilter: func(b []byte) []byte {
+			// Strip the carrier's control byte.
+			return b[1:]
+		},
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	p := pdu.NewDeliverSM()
+	f := p.Fields()
+	f.Set(pdufield.SourceAddr, "5551234")
+	f.Set(pdufield.DestinationAddr, "root")
+	f.Set(pdufield.ShortMessage, pdutext.Latin1("\x0aolá mundo"))
+	s.BroadcastMessage(p)
+	select {
+	case m := <-rc:
+		f := m.Fields()
+		dc := pdutext.DataCoding(f[pdufield.DataCoding].Raw().(uint8))
+		text := pdutext.Decode(dc, f[pdufield.ShortMessage].Bytes())
+		if string(text) != "olá mundo" {
+			t.Fatalf("unexpected text: want %q, have %q", "olá mundo", text)
+		}
+		if n := f[pdufield.SMLength].Raw().(uint8); n != 9 {
+			t.Fatalf("unexpected sm_length: want 9, have %d", n)
+		}
+		if raw := OriginalShortMessage(m); string(raw) != "\x0aol\xe1 mundo" {
+			t.Fatalf("unexpected raw short_message: %q", raw)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for deliver_sm")
+	}
+}
+
+func TestReceiverDataCodings(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	raw := make(chan pdu.Body, 1)
+	r := &Receiver{
+		Addr:        s.Addr(),
+		User:        smpptest.DefaultUser,
+		Passwd:      smpptest.DefaultPasswd,
+		Handler:     func(p pdu.Body) { rc <- p },
+		RawHandler:  func(p pdu.Body) { raw <- p },
+		DataCodings: []pdutext.DataCoding{pdutext.DefaultType, pdutext.UCS2Type},
+		ShortMessageFilter: func(b []byte) []byte {
+			// Strip the carrier's control byte.
+			return b[1:]
+		},
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	p := pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.SourceAddr, "5551234")
+	p.Fields().Set(pdufield.DestinationAddr, "root")
+	p.Fields().Set(pdufield.ShortMessage, pdutext.Latin1("\x0aolá mundo"))
+	s.BroadcastMessage(p)
+	select {
+	case m := <-raw:
+		f := m.Fields()
+		if sm := f[pdufield.ShortMessage].Bytes(); string(sm) != "\x0aol\xe1 mundo" {
+			t.Fatalf("unexpected short_message: want it as received, have %q", sm)
+		}
+		if dc := f[pdufield.DataCoding].Raw().(uint8); dc != uint8(pdutext.Latin1Type) {
+			t.Fatalf("unexpected data_coding: want %#x, have %#x", pdutext.Latin1Type, dc)
+		}
+	case m := <-rc:
+		t.Fatalf("unexpected PDU with unaccepted data_coding in Handler: %#v", m)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for deliver_sm")
+	}
+	p = pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.SourceAddr, "5551234")
+	p.Fields().Set(pdufield.DestinationAddr, "root")
+	p.Fields().Set(pdufield.ShortMessage, pdutext.Raw("\x0ahello"))
+	s.BroadcastMessage(p)
+	select {
+	case m := <-rc:
+		f := m.Fields()
+		if sm := f[pdufield.ShortMessage].Bytes(); string(sm) != "hello" {
+			t.Fatalf("unexpected short_message: want %q, have %q", "hello", sm)
+		}
+	case m := <-raw:
+		t.Fatalf("unexpected PDU with accepted data_coding in RawHandler: %#v", m)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for deliver_sm")
+	}
+}
+
+func TestReceiverMergeAcrossRebind(t *testing.T) {
+	var drop sync.Once
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		if p.Header().ID == pdu.DeliverSMRespID {
+			// Drop the link after the first part.
+			drop.Do(func() { c.Close() })
+		}
+	}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	r := &Receiver{
+		Addr:              s.Addr(),
+		User:              smpptest.DefaultUser,
+		Passwd:            smpptest.DefaultPasswd,
+		BindInterval:      10 * time.Millisecond,
+		MergeInterval:     time.Second,
+		MergeAcrossRebind: true,
+		Handler:           func(p pdu.Body) { rc <- p },
+	}
+	defer r.Close()
+	status := r.Bind()
+	conn := <-status
+	if conn.Status() != Connected {
+		t.Fatal(conn.Error())
+	}
+	part := func(n uint8, text string) pdu.Body {
+		p := pdu.NewDeliverSM()
+		f := p.Fields()
+		f.Set(pdufield.SourceAddr, "5551234")
+		f.Set(pdufield.DestinationAddr, "root")
+		f.Set(pdufield.ESMClass, 0x40)
+		f.Set(pdufield.ShortMessage, append([]byte{0x05, 0x00, 0x03, 0x2a, 0x02, n}, text...))
+		return p
+	}
+	s.BroadcastMessage(part(1, "hello "))
+	for _, want := range []ConnStatusID{Disconnected, Connected} {
+		select {
+		case conn = <-status:
+			if conn.Status() != want {
+				t.Fatalf("unexpected status: want %s, have %s", want, conn.Status())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %s", want)
+		}
+	}
+	s.BroadcastMessage(part(2, "world"))
+	select {
+	case m := <-rc:
+		sm := m.Fields()[pdufield.ShortMessage]
+		if text := sm.String(); text != "hello world" {
+			t.Fatalf("unexpected short_message: want %q, have %q", "hello world", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for merged deliver_sm")
+	}
+}
+
+func TestReceiverMergeRedelivery(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 2)
+	r := &Receiver{
+		Addr:          s.Addr(),
+		User:          smpptest.DefaultUser,
+		Passwd:        smpptest.DefaultPasswd,
+		MergeInterval: time.Second,
+		Handler:       func(p pdu.Body) { rc <- p },
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	if conn.Status() != Connected {
+		t.Fatal(conn.Error())
+	}
+	part := func(total, n uint8, text string) pdu.Body {
+		p := pdu.NewDeliverSM()
+		f := p.Fields()
+		f.Set(pdufield.SourceAddr, "5551234")
+		f.Set(pdufield.DestinationAddr, "root")
+		f.Set(pdufield.ESMClass, 0x40)
+		f.Set(pdufield.ShortMessage, append([]byte{0x05, 0x00, 0x03, 0x2a, total, n}, text...))
+		return p
+	}
+	s.BroadcastMessage(part(2, 1, "hello "))
+	s.BroadcastMessage(part(2, 2, "world"))
+	select {
+	case m := <-rc:
+		sm := m.Fields()[pdufield.ShortMessage]
+		if text := sm.String(); text != "hello world" {
+			t.Fatalf("unexpected short_message: want %q, have %q", "hello world", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for merged deliver_sm")
+	}
+	// A part delivered again after the merge starts a new message,
+	// and parts with another total are not merged into it.
+	s.BroadcastMessage(part(2, 2, "world"))
+	s.BroadcastMessage(part(1, 1, "hi"))
+	s.BroadcastMessage(part(3, 3, "!"))
+	select {
+	case m := <-rc:
+		t.Fatalf("unexpected deliver_sm: %q", m.Fields()[pdufield.ShortMessage])
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.BroadcastMessage(part(2, 1, "hello "))
+	select {
+	case m := <-rc:
+		sm := m.Fields()[pdufield.ShortMessage]
+		if text := sm.String(); text != "hello world" {
+			t.Fatalf("unexpected short_message: want %q, have %q", "hello world", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for merged deliver_sm")
+	}
+}
+
+func TestReceiverMergeExpiry(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	r := &Receiver{
+		Addr:                 s.Addr(),
+		User:                 smpptest.DefaultUser,
+		Passwd:               smpptest.DefaultPasswd,
+		MergeInterval:        50 * time.Millisecond,
+		MergeCleanupInterval: 20 * time.Millisecond,
+		Handler:              func(p pdu.Body) { rc <- p },
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	if conn.Status() != Connected {
+		t.Fatal(conn.Error())
+	}
+	part := func(n uint8, text string) pdu.Body {
+		p := pdu.NewDeliverSM()
+		f := p.Fields()
+		f.Set(pdufield.SourceAddr, "5551234")
+		f.Set(pdufield.DestinationAddr, "root")
+		f.Set(pdufield.ESMClass, 0x40)
+		f.Set(pdufield.ShortMessage, append([]byte{0x05, 0x00, 0x03, 0x2a, 0x02, n}, text...))
+		return p
+	}
+	s.BroadcastMessage(part(1, "hello "))
+	// The part outlives the first cleanup, and expires at a later one.
+	time.Sleep(200 * time.Millisecond)
+	r.mg.Lock()
+	n := len(r.mg.mergeHolders)
+	r.mg.Unlock()
+	if n != 0 {
+		t.Fatalf("unexpected parts waiting for merge: want 0, have %d", n)
+	}
+	s.BroadcastMessage(part(2, "world"))
+	select {
+	case m := <-rc:
+		t.Fatalf("unexpected merge with expired part: %q", m.Fields()[pdufield.ShortMessage])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReceiverNoHandler(t *testing.T) {
+	respc := make(chan pdu.Body, 2)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		if p.Header().ID == pdu.DeliverSMRespID {
+			respc <- p
+		}
+	}
+	s.Start()
+	defer s.Close()
+	r := &Receiver{
+		Addr:            s.Addr(),
+		User:            smpptest.DefaultUser,
+		Passwd:          smpptest.DefaultPasswd,
+		NoHandlerBuffer: 1,
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	test := []struct {
+		text string
+		want pdu.Status
+	}{
+		{"kept", 0},
+		{"rejected", statusReceiverTempAppError},
+	}
+	for _, tc := range test {
+		p := pdu.NewDeliverSM()
+		p.Fields().Set(pdufield.SourceAddr, "5551234")
+		p.Fields().Set(pdufield.DestinationAddr, "root")
+		p.Fields().Set(pdufield.ShortMessage, tc.text)
+		s.BroadcastMessage(p)
+		select {
+		case resp := <-respc:
+			if resp.Header().Seq != p.Header().Seq {
+				t.Fatalf("unexpected resp seq: want %d, have %d", p.Header().Seq, resp.Header().Seq)
+			}
+			if resp.Header().Status != tc.want {
+				t.Fatalf("unexpected resp status: want %#x, have %#x", tc.want, resp.Header().Status)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for deliver_sm_resp")
+		}
+	}
+	rc := make(chan pdu.Body, 2)
+	r.SetHandler(func(p pdu.Body) { rc <- p })
+	select {
+	case m := <-rc:
+		if text := m.Fields()[pdufield.ShortMessage].String(); text != "kept" {
+			t.Fatalf("unexpected short_message: want kept, have %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for kept deliver_sm")
+	}
+	select {
+	case m := <-rc:
+		t.Fatalf("unexpected PDU: %#v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// A Handler may replace itself.
+	next := make(chan pdu.Body, 1)
+	r.SetHandler(func(p pdu.Body) {
+		r.SetHandler(func(p pdu.Body) { next <- p })
+		rc <- p
+	})
+	for _, c := range []chan pdu.Body{rc, next} {
+		p := pdu.NewDeliverSM()
+		p.Fields().Set(pdufield.SourceAddr, "5551234")
+		p.Fields().Set(pdufield.DestinationAddr, "root")
+		p.Fields().Set(pdufield.ShortMessage, "hello")
+		s.BroadcastMessage(p)
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for deliver_sm")
+		}
+	}
+}
+
+func TestReceiverHandlerAfterBind(t *testing.T) {
+	respc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		if p.Header().ID == pdu.DeliverSMRespID {
+			respc <- p
+		}
+	}
+	s.Start()
+	defer s.Close()
+	r := &Receiver{
+		Addr:            s.Addr(),
+		User:            smpptest.DefaultUser,
+		Passwd:          smpptest.DefaultPasswd,
+		NoHandlerBuffer: 1,
+	}
+	defer r.Close()
+	conn := <-r.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	send := func(text string) {
+		p := pdu.NewDeliverSM()
+		p.Fields().Set(pdufield.SourceAddr, "5551234")
+		p.Fields().Set(pdufield.DestinationAddr, "root")
+		p.Fields().Set(pdufield.ShortMessage, text)
+		s.BroadcastMessage(p)
+		select {
+		case <-respc:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for deliver_sm_resp")
+		}
+	}
+	send("kept")
+	rc := make(chan pdu.Body, 2)
+	r.Handler = func(p pdu.Body) { rc <- p }
+	send("hello")
+	for _, want := range []string{"kept", "hello"} {
+		select {
+		case m := <-rc:
+			if text := m.Fields()[pdufield.ShortMessage].String(); text != want {
+				t.Fatalf("unexpected short_message: want %s, have %q", want, text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for deliver_sm %s", want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import "github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+
+// ConcatMode is the kind of user data header used to concatenate the
+// segments of a long message.
+type ConcatMode int
+
+// Supported concatenation modes.
+const (
+	Concat16 ConcatMode = iota // 16 bit reference number, as sent by SubmitLongMsg.
+	Concat8                    // 8 bit reference number.
+)
+
+// udhLen returns the length of the concatenation user data header,
+// including its length octet.
+func (m ConcatMode) udhLen() int {
+	if m == Concat8 {
+		return 6
+	}
+	return 7
+}
+
+// userDataLen returns the room for text in a short message with a user
+// data header of udhLen octets, in the unit of pdutext.EncodedLen:
+// septets for GSM7, packed by the SMSC, 8 in 7 octets, and octets for
+// other codecs. UCS2 is kept even, so a character is never split.
+func userDataLen(text pdutext.Codec, udhLen int) int {
+	n := maxUserData - udhLen
+	if _, ok := text.(pdutext.GSM7); ok {
+		return n * 8 / 7
+	}
+	if text.Type() == pdutext.UCS2Type {
+		n -= n % 2
+	}
+	return n
+}
+
+// segmentLen returns the length of the text of each segment of a long
+// message, in the unit of pdutext.EncodedLen, see userDataLen.
+func segmentLen(text pdutext.Codec, mode ConcatMode) int {
+	return userDataLen(text, mode.udhLen())
+}
+
+// gsm7Escape is the GSM7 escape to the extension table, sent along with
+// the next septet.
+const gsm7Escape = 0x1B
+
+// splitSegments splits b, the encoded text, in segments of up to
+// segmentLen octets. GSM7 segments end before an escape that would be
+// split from the septet it escapes.
+func splitSegments(text pdutext.Codec, b []byte, mode ConcatMode) [][]byte {
+	l := segmentLen(text, mode)
+	_, gsm7 := text.(pdutext.GSM7)
+	var segments [][]byte
+	for len(b) > l {
+		n := l
+		if gsm7 {
+			// Walk the septets, an escape takes two.
+			i := 0
+			for i < l {
+				w := 1
+				if b[i] == gsm7Escape {
+					w = 2
+				}
+				if i+w > l {
+					break
+				}
+				i += w
+			}
+			n = i
+		}
+		segments = append(segments, b[:n])
+		b = b[n:]
+	}
+	return append(segments, b)
+}
+
+// SegmentRemaining returns the room left in the current, last, segment
+// of text, and its index, starting at 1. Text up to 160 septets for
+// GSM7, or 140 octets for other codecs, fits a single short message;
+// longer text is split in segments that carry the concatenation user
+// data header of mode, leaving less room for text in each. Use it to
+// tell how much can still be typed before another segment is needed.
+// Room is counted as pdutext.EncodedLen does: in septets for GSM7, one
+// per character and two per character of the extension table, and in
+// octets for other codecs, two per character for UCS2.
+func SegmentRemaining(text pdutext.Codec, mode ConcatMode) (remaining, segment int) {
+	n := pdutext.EncodedLen(text)
+	if max := userDataLen(text, 0); n <= max {
+		return max - n, 1
+	}
+	l := segmentLen(text, mode)
+	if _, ok := text.(pdutext.GSM7); ok {
+		// Escapes are not split, segments may be shorter.
+		segments := splitSegments(text, text.Encode(), mode)
+		return l - len(segments[len(segments)-1]), len(segments)
+	}
+	segment = (n-1)/l + 1
+	return segment*l - n, segment
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+)
+
+func TestSegmentRemaining(t *testing.T) {
+	test := []struct {
+		text      pdutext.Codec
+		mode      ConcatMode
+		remaining int
+		segment   int
+	}{
+		{pdutext.GSM7(""), Concat16, 160, 1},
+		{pdutext.GSM7(strings.Repeat("a", 159)), Concat16, 1, 1},
+		{pdutext.GSM7(strings.Repeat("a", 160)), Concat16, 0, 1},
+		// One more character needs a second segment, both with UDH.
+		{pdutext.GSM7(strings.Repeat("a", 161)), Concat16, 2*152 - 161, 2},
+		{pdutext.GSM7(strings.Repeat("a", 161)), Concat8, 2*153 - 161, 2},
+		{pdutext.GSM7(strings.Repeat("a", 304)), Concat16, 0, 2},
+		{pdutext.GSM7(strings.Repeat("a", 305)), Concat16, 3*152 - 305, 3},
+		// Extension table characters take two septets.
+		{pdutext.GSM7(strings.Repeat("a", 158) + "€"), Concat16, 0, 1},
+		{pdutext.GSM7(strings.Repeat("a", 159) + "€"), Concat16, 2*152 - 161, 2},
+		// Nor split from their escape between segments.
+		{pdutext.GSM7(strings.Repeat("a", 151) + "€" + strings.Repeat("a", 10)), Concat16, 152 - 12, 2},
+		{pdutext.Raw(strings.Repeat("a", 140)), Concat16, 0, 1},
+		{pdutext.Raw(strings.Repeat("a", 141)), Concat16, 2*133 - 141, 2},
+		{pdutext.UCS2(strings.Repeat("á", 70)), Concat16, 0, 1},
+		{pdutext.UCS2(strings.Repeat("á", 71)), Concat16, 2*132 - 142, 2},
+		{pdutext.UCS2(strings.Repeat("á", 71)), Concat8, 2*134 - 142, 2},
+		{pdutext.UCS2(strings.Repeat("á", 132)), Concat16, 0, 2},
+	}
+	for i, tc := range test {
+		remaining, segment := SegmentRemaining(tc.text, tc.mode)
+		if remaining != tc.remaining || segment != tc.segment {
+			t.Fatalf("unexpected capacity for test %d: want %d in segment %d, have %d in segment %d",
+				i, tc.remaining, tc.segment, remaining, segment)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpptest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+)
+
+// ReceiptMode selects the PDU that carries delivery receipts.
+type ReceiptMode int
+
+// Supported receipt modes.
+const (
+	// ReceiptDeliverSM sends receipts in the short_message of a
+	// deliver_sm, as most SMSCs do.
+	ReceiptDeliverSM ReceiptMode = iota
+
+	// ReceiptDataSM sends receipts in the message_payload of a
+	// data_sm.
+	ReceiptDataSM
+)
+
+// receiptDateLayout is the layout of the dates of delivery receipts.
+const receiptDateLayout = "0601021504"
+
+// ReceiptHandler returns a HandlerFunc that replies to submit_sm with a
+// submit_sm_resp, and then sends a delivery receipt for it as of mode
+// if registered_delivery requests one. Receipts report the message as
+// delivered, with the receipted_message_id and message_state TLVs set.
+// Responses to receipts are ignored, and any other PDUs echoed back.
+func ReceiptHandler(mode ReceiptMode) HandlerFunc {
+	var id uint32
+	return func(c Conn, m pdu.Body) {
+		switch m.Header().ID {
+		case pdu.SubmitSMID:
+			msgid := fmt.Sprintf("%08x", atomic.AddUint32(&id, 1))
+			resp := pdu.NewSubmitSMResp()
+			resp.Header().Seq = m.Header().Seq
+			resp.Fields().Set(pdufield.MessageID, msgid)
+			if err := c.Write(resp); err != nil {
+				return
+			}
+			f := m.Fields()
+			rd, ok := f[pdufield.RegisteredDelivery]
+			if !ok || rd.Bytes()[0]&byte(pdufield.FinalDeliveryReceipt) == 0 {
+				return
+			}
+			c.Write(newReceipt(mode, msgid, f))
+		case pdu.DeliverSMRespID, pdu.DataSMRespID:
+		default:
+			EchoHandler(c, m)
+		}
+	}
+}
+
+// newReceipt returns the delivery receipt of message msgid, submitted
+// with fields f.
+func newReceipt(mode ReceiptMode, msgid string, f pdufield.Map) pdu.Body {
+	text := ""
+	if sm, ok := f[pdufield.ShortMessage]; ok {
+		text = sm.String()
+	}
+	if len(text) > 20 {
+		text = text[:20]
+	}
+	now := time.Now().UTC().Format(receiptDateLayout)
+	receipt := fmt.Sprintf("id:%s sub:001 dlvrd:001 submit date:%s done date:%s stat:DELIVRD err:000 text:%s",
+		msgid, now, now, text)
+	var p pdu.Body
+	if mode == ReceiptDataSM {
+		p = pdu.NewDataSM()
+		p.TLVFields().Set(pdufield.MessagePayload, receipt)
+	} else {
+		p = pdu.NewDeliverSM()
+		p.Fields().Set(pdufield.ShortMessage, receipt)
+	}
+	rf := p.Fields()
+	rf.Set(pdufield.SourceAddr, f[pdufield.DestinationAddr])
+	rf.Set(pdufield.DestinationAddr, f[pdufield.SourceAddr])
+	rf.Set(pdufield.ESMClass, 0x04) // SMSC delivery receipt.
+	tlv := p.TLVFields()
+	tlv.Set(pdufield.ReceiptedMessageID, msgid+"\x00")
+	tlv.Set(pdufield.MessageStateOption, uint8(pdufield.StateDelivered))
+	return p
+}
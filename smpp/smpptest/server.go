@@ -61,6 +61,18 @@ func NewUnstartedServer() *Server {
 	}
 }
 
+// NewUnstartedServerListener creates a new Server with default settings,
+// accepting clients on the given listener, e.g. of a Unix socket, and
+// does not start it. Callers are supposed to call Start and Close later.
+func NewUnstartedServerListener(l net.Listener) *Server {
+	return &Server{
+		User:    DefaultUser,
+		Passwd:  DefaultPasswd,
+		Handler: EchoHandler,
+		l:       l,
+	}
+}
+
 func newLocalListener() net.Listener {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err == nil {
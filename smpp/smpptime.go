@@ -0,0 +1,63 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidSMPPTime is returned by ParseSMPPTime when the given
+// string is not an absolute time, e.g. schedule_delivery_time.
+var ErrInvalidSMPPTime = errors.New("invalid SMPP absolute time")
+
+// smppTimeLayout is the layout of the YYMMDDhhmmss prefix of the SMPP
+// time format.
+const smppTimeLayout = "060102150405"
+
+// ParseSMPPTime parses an absolute time in the format of the SMPP
+// time fields, YYMMDDhhmmsstnnp, see SMPP 3.4 spec 7.1.1: t is the
+// tenths of second, nn the offset from UTC in quarter hours, and p
+// is '+' or '-'. Relative times, where p is 'R', are not accepted.
+func ParseSMPPTime(s string) (time.Time, error) {
+	if len(s) != 16 || (s[15] != '+' && s[15] != '-') {
+		return time.Time{}, ErrInvalidSMPPTime
+	}
+	t, err := time.Parse(smppTimeLayout, s[:12])
+	if err != nil {
+		return time.Time{}, ErrInvalidSMPPTime
+	}
+	tenths := s[12]
+	nn, err := strconv.Atoi(s[13:15])
+	if tenths < '0' || tenths > '9' || err != nil || nn < 0 || nn > 48 {
+		return time.Time{}, ErrInvalidSMPPTime
+	}
+	offset := nn * 15 * 60
+	if s[15] == '-' {
+		offset = -offset
+	}
+	loc := time.FixedZone("", offset)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(),
+		t.Second(), int(tenths-'0')*int(100*time.Millisecond), loc), nil
+}
+
+// FormatSMPPTime formats t as an absolute time in the format of
+// the SMPP time fields, see ParseSMPPTime. The time is formatted in
+// UTC if its offset is not a whole number of quarter hours.
+func FormatSMPPTime(t time.Time) string {
+	_, offset := t.Zone()
+	if offset%(15*60) != 0 {
+		t, offset = t.UTC(), 0
+	}
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	tenths := t.Nanosecond() / int(100*time.Millisecond)
+	return fmt.Sprintf("%s%d%02d%c", t.Format(smppTimeLayout), tenths,
+		offset/(15*60), sign)
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSMPPTime(t *testing.T) {
+	test := []struct {
+		text string
+		want time.Time
+	}{
+		{"991231235959000+", time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{"200315083015304-", time.Date(2020, 3, 15, 8, 30, 15, 3e8, time.FixedZone("", -3600))},
+		{"170101120000748+", time.Date(2017, 1, 1, 12, 0, 0, 7e8, time.FixedZone("", 12*3600))},
+		{"160930141500022-", time.Date(2016, 9, 30, 14, 15, 0, 0, time.FixedZone("", -(5*3600+30*60)))},
+	}
+	for _, tc := range test {
+		have, err := ParseSMPPTime(tc.text)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.text, err)
+		}
+		if !have.Equal(tc.want) {
+			t.Fatalf("unexpected time for %q: want %s, have %s", tc.text, tc.want, have)
+		}
+		_, wantOffset := tc.want.Zone()
+		if _, offset := have.Zone(); offset != wantOffset {
+			t.Fatalf("unexpected offset for %q: want %d, have %d", tc.text, wantOffset, offset)
+		}
+		if s := FormatSMPPTime(have); s != tc.text {
+			t.Fatalf("unexpected format: want %q, have %q", tc.text, s)
+		}
+	}
+	for _, s := range []string{"", "000010000000000R", "991231235959000", "991331235959000+", "99123123595900a+", "991231235959049+"} {
+		if _, err := ParseSMPPTime(s); err != ErrInvalidSMPPTime {
+			t.Fatalf("unexpected error for %q: want ErrInvalidSMPPTime, have %v", s, err)
+		}
+	}
+	odd := time.Date(2020, 1, 1, 0, 0, 0, 0, time.FixedZone("", 60))
+	if s := FormatSMPPTime(odd); s != "191231235900000+" {
+		t.Fatalf("unexpected format of odd offset: want UTC, have %q", s)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import "sync"
+
+// SplitBind implements an SMPP client that manages a bind_transmitter
+// and a bind_receiver on separate connections as one session. Submit
+// and other requests go out on the transmitter, while PDUs arriving
+// on the receiver, such as deliver_sm, are passed to Handler. Each
+// connection is reconnected independently.
+//
+// The Transmitter is configured as usual. The Receiver defaults to
+// the server address, credentials, system type and TLS settings of
+// the Transmitter, other settings are its own.
+type SplitBind struct {
+	Transmitter
+	Receiver Receiver
+	Handler  HandlerFunc // Receiver handler, unless Receiver.Handler is set.
+
+	mu     sync.Mutex
+	status chan ConnStatus
+}
+
+// SplitStatus is the status of either connection of a SplitBind.
+type SplitStatus struct {
+	ConnStatus
+	Receiver bool // Whether it is the status of the receiver, or of the transmitter.
+}
+
+// Bind starts both the transmitter and the receiver. The status of
+// each of them is sent to the returned channel as a SplitStatus.
+//
+// Bind implements the ClientConn interface.
+func (sb *SplitBind) Bind() <-chan ConnStatus {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.status != nil {
+		return sb.status
+	}
+	r := &sb.Receiver
+	if r.Addr == "" {
+		r.Addr = sb.Transmitter.Addr
+		r.Network = sb.Transmitter.Network
+	}
+	if r.User == "" {
+		r.User = sb.Transmitter.User
+		r.Passwd = sb.Transmitter.Passwd
+	}
+	if r.SystemType == "" {
+		r.SystemType = sb.Transmitter.SystemType
+	}
+	if r.TLS == nil {
+		r.TLS = sb.Transmitter.TLS
+	}
+	if r.Handler == nil {
+		r.Handler = sb.Handler
+	}
+	sb.status = make(chan ConnStatus, 2)
+	go sb.notify(sb.Transmitter.Bind(), r.Bind())
+	return sb.status
+}
+
+// notify forwards the status of both connections, until both are
+// closed.
+func (sb *SplitBind) notify(tx, rx <-chan ConnStatus) {
+	defer close(sb.status)
+	for tx != nil || rx != nil {
+		var st SplitStatus
+		var ok bool
+		select {
+		case st.ConnStatus, ok = <-tx:
+			if !ok {
+				tx = nil
+				continue
+			}
+		case st.ConnStatus, ok = <-rx:
+			if !ok {
+				rx = nil
+				continue
+			}
+			st.Receiver = true
+		}
+		select {
+		case sb.status <- st:
+		default:
+		}
+	}
+}
+
+// Close closes both connections.
+//
+// Close implements the ClientConn interface.
+func (sb *SplitBind) Close() error {
+	err := sb.Transmitter.Close()
+	if rerr := sb.Receiver.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
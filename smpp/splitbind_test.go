@@ -0,0 +1,103 @@
+// Copyright 2015 go-smpp authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package smpp
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+	"github.com/fiorix/go-smpp/smpp/smpptest"
+)
+
+func TestSplitBind(t *testing.T) {
+	var submits [2]int32
+	newServer := func(i int) *smpptest.Server {
+		s := smpptest.NewUnstartedServer()
+		s.Handler = func(c smpptest.Conn, p pdu.Body) {
+			switch p.Header().ID {
+			case pdu.SubmitSMID:
+				atomic.AddInt32(&submits[i], 1)
+				r := pdu.NewSubmitSMResp()
+				r.Header().Seq = p.Header().Seq
+				r.Fields().Set(pdufield.MessageID, "foobar")
+				c.Write(r)
+			case pdu.DeliverSMRespID:
+			default:
+				smpptest.EchoHandler(c, p)
+			}
+		}
+		s.Start()
+		return s
+	}
+	txs, rxs := newServer(0), newServer(1)
+	defer txs.Close()
+	defer rxs.Close()
+	rc := make(chan pdu.Body, 2)
+	sb := &SplitBind{
+		Transmitter: Transmitter{
+			Addr:   txs.Addr(),
+			User:   smpptest.DefaultUser,
+			Passwd: smpptest.DefaultPasswd,
+		},
+		Receiver: Receiver{Addr: rxs.Addr()},
+		Handler:  func(p pdu.Body) { rc <- p },
+	}
+	defer sb.Close()
+	status := sb.Bind()
+	var bound [2]bool
+	for !bound[0] || !bound[1] {
+		select {
+		case st := <-status:
+			if st.Status() != Connected {
+				t.Fatal(st.Error())
+			}
+			if st.(SplitStatus).Receiver {
+				bound[1] = true
+			} else {
+				bound[0] = true
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for binds: %v", bound)
+		}
+	}
+	sm, err := sb.Submit(&ShortMessage{
+		Src:  "root",
+		Dst:  "foobar",
+		Text: pdutext.Raw("Lorem ipsum"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := sm.RespID(); id != "foobar" {
+		t.Fatalf("unexpected message id: want foobar, have %q", id)
+	}
+	if tx, rx := atomic.LoadInt32(&submits[0]), atomic.LoadInt32(&submits[1]); tx != 1 || rx != 0 {
+		t.Fatalf("unexpected submits: want 1 on transmitter and 0 on receiver, have %d and %d", tx, rx)
+	}
+	// Only PDUs of the receiver reach the handler.
+	p := pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.ShortMessage, "from transmitter")
+	txs.BroadcastMessage(p)
+	p = pdu.NewDeliverSM()
+	p.Fields().Set(pdufield.ShortMessage, "from receiver")
+	rxs.BroadcastMessage(p)
+	select {
+	case p := <-rc:
+		if sm := p.Fields()[pdufield.ShortMessage].String(); sm != "from receiver" {
+			t.Fatalf("unexpected short_message: want %q, have %q", "from receiver", sm)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for deliver_sm")
+	}
+	select {
+	case p := <-rc:
+		t.Fatalf("unexpected PDU: %#v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -8,28 +8,50 @@ import (
 	"crypto/tls"
 	"fmt"
 	"math/rand"
+	"net"
 	"time"
 
 	"github.com/fiorix/go-smpp/smpp/pdu"
 	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
 )
 
 // Transceiver implements an SMPP transceiver.
 //
 // The API is a combination of the Transmitter and Receiver.
 type Transceiver struct {
-	Addr               string        // Server address in form of host:port.
-	User               string        // Username.
-	Passwd             string        // Password.
-	SystemType         string        // System type, default empty.
-	EnquireLink        time.Duration // Enquire link interval, default 10s.
-	EnquireLinkTimeout time.Duration // Time after last EnquireLink response when connection considered down
-	RespTimeout        time.Duration // Response timeout, default 1s.
-	BindInterval       time.Duration // Binding retry interval
-	TLS                *tls.Config   // TLS client settings, optional.
-	Handler            HandlerFunc   // Receiver handler, optional.
-	RateLimiter        RateLimiter   // Rate limiter, optional.
-	WindowSize         uint
+	Addr                 string                       // Server address in form of host:port.
+	Network              string                       // Network of Addr, tcp or unix for a socket path, default tcp.
+	User                 string                       // Username.
+	Passwd               string                       // Password.
+	SystemType           string                       // System type, default empty.
+	EnquireLink          time.Duration                // Enquire link interval, default 10s.
+	EnquireLinkTimeout   time.Duration                // Time after last EnquireLink response when connection considered down
+	CoalesceEnquireLink  bool                         // Send EnquireLink only when no PDU was sent for EnquireLink, responses count for EnquireLinkTimeout meanwhile, optional.
+	AnyPDUKeepAlive      bool                         // Count any received PDU, not only EnquireLinkResp, for EnquireLinkTimeout, optional.
+	RespTimeout          time.Duration                // Response timeout, default 1s.
+	RetryOnReconnect     time.Duration                // Time a Submit may wait for a reconnect in progress, retrying once on connection errors, optional.
+	TrackUserRef         bool                         // Track submits by user_message_reference and ignore late responses of superseded attempts, optional.
+	OrderByDst           bool                         // Write requests to the same destination in the order they are made, optional.
+	DefaultTLVs          pdufield.TLVMap              // TLVs set on every submit that does not set them, optional.
+	OnBeforeSend         func(seq uint32, p pdu.Body) // Called with the sequence number of each request before it is written, optional.
+	MessageIDNormalizer  func(id string) string       // Rewrites message_id of responses and delivery receipts to a canonical form, optional.
+	DialTimeout          time.Duration                // Timeout for connecting to the server, optional.
+	LocalAddr            net.Addr                     // Local address to connect from, optional.
+	BindTimeout          time.Duration                // Timeout for the bind response, optional.
+	WriteTimeout         time.Duration                // Timeout for writing a PDU, after which the connection is closed, optional.
+	BindInterval         time.Duration                // Binding retry interval
+	AlreadyBoundInterval time.Duration                // Binding retry interval after ESME_RALYBND, optional.
+	BindLimit            *BindLimit                   // Limit of concurrent sessions, shared with the clients given the same one, optional.
+	TLS                  *tls.Config                  // TLS client settings, optional.
+	BindTracer           func(bt *BindTrace)          // Called with the details of each bind attempt, optional.
+	Handler              HandlerFunc                  // Receiver handler, optional.
+	ShortMessageFilter   func(b []byte) []byte        // Rewrites inbound short_message before Handler, optional.
+	DataCodings          []pdutext.DataCoding         // Accepted data_coding of inbound PDUs, any if empty, optional.
+	SMLengthPolicy       *pdufield.SMLengthPolicy     // How to decode inbound PDUs whose sm_length disagrees with the short message, optional.
+	RawHandler           HandlerFunc                  // Handler of inbound PDUs with other data_coding, delivered as received, optional.
+	RateLimiter          RateLimiter                  // Rate limiter, optional.
+	WindowSize           uint
 
 	Transmitter
 }
@@ -44,18 +66,40 @@ func (t *Transceiver) Bind() <-chan ConnStatus {
 	}
 	t.tx.Lock()
 	t.tx.inflight = make(map[uint32]chan *tx)
+	t.tx.pending = make(map[uint32]PendingInfo)
+	t.tx.refs = make(map[uint16]uint32)
+	t.tx.stale = make(map[uint32]time.Time)
 	t.tx.Unlock()
 	c := &client{
-		Addr:               t.Addr,
-		TLS:                t.TLS,
-		Status:             make(chan ConnStatus, 1),
-		BindFunc:           t.bindFunc,
-		EnquireLink:        t.EnquireLink,
-		EnquireLinkTimeout: t.EnquireLinkTimeout,
-		RespTimeout:        t.RespTimeout,
-		WindowSize:         t.WindowSize,
-		RateLimiter:        t.RateLimiter,
-		BindInterval:       t.BindInterval,
+		Addr:                 t.Addr,
+		Network:              t.Network,
+		TLS:                  t.TLS,
+		Status:               make(chan ConnStatus, 1),
+		BindFunc:             t.bindFunc,
+		EnquireLink:          t.EnquireLink,
+		EnquireLinkTimeout:   t.EnquireLinkTimeout,
+		CoalesceEnquireLink:  t.CoalesceEnquireLink,
+		AnyPDUKeepAlive:      t.AnyPDUKeepAlive,
+		RespTimeout:          t.RespTimeout,
+		RetryOnReconnect:     t.RetryOnReconnect,
+		TrackUserRef:         t.TrackUserRef,
+		OrderByDst:           t.OrderByDst,
+		DefaultTLVs:          t.DefaultTLVs,
+		OnBeforeSend:         t.OnBeforeSend,
+		MessageIDNormalizer:  t.MessageIDNormalizer,
+		WindowSize:           t.WindowSize,
+		RateLimiter:          t.RateLimiter,
+		DialTimeout:          t.DialTimeout,
+		LocalAddr:            t.LocalAddr,
+		BindTimeout:          t.BindTimeout,
+		WriteTimeout:         t.WriteTimeout,
+		BindInterval:         t.BindInterval,
+		AlreadyBoundInterval: t.AlreadyBoundInterval,
+		BindLimit:            t.BindLimit,
+		BindTracer:           t.BindTracer,
+		ShortMessageFilter:   t.ShortMessageFilter,
+		DataCodings:          t.DataCodings,
+		SMLengthPolicy:       t.SMLengthPolicy,
 	}
 	t.cl.client = c
 	c.init()
@@ -69,7 +113,7 @@ func (t *Transceiver) bindFunc(c Conn) error {
 	f.Set(pdufield.SystemID, t.User)
 	f.Set(pdufield.Password, t.Passwd)
 	f.Set(pdufield.SystemType, t.SystemType)
-	resp, err := bind(c, p)
+	resp, err := bind(c, p, t.cl.BindTracer)
 	if err != nil {
 		return err
 	}
@@ -77,6 +121,6 @@ func (t *Transceiver) bindFunc(c Conn) error {
 		return fmt.Errorf("unexpected response for BindTransceiver: %s",
 			resp.Header().ID)
 	}
-	go t.handlePDU(t.Handler)
+	go t.handlePDU(routeRaw(t.Handler, t.RawHandler, t.DataCodings))
 	return nil
 }
@@ -6,6 +6,8 @@ package smpp
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -89,3 +91,236 @@ func TestTransceiver(t *testing.T) {
 		t.Fatal("timeout waiting for ack")
 	}
 }
+
+func TestTransceiverTrackUserRef(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		if p.Header().ID != pdu.SubmitSMID {
+			smpptest.EchoHandler(c, p)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		r := pdu.NewSubmitSMResp()
+		r.Header().Seq = p.Header().Seq
+		r.Fields().Set(pdufield.MessageID, fmt.Sprintf("attempt-%d", n))
+		switch n {
+		case 1: // Answer after the client gave up.
+			go func() {
+				time.Sleep(300 * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				c.Write(r)
+			}()
+		case 3: // Never answer.
+		default:
+			c.Write(r)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	unsolicited := make(chan pdu.Body, 1)
+	tc := &Transceiver{
+		Addr:         s.Addr(),
+		User:         smpptest.DefaultUser,
+		Passwd:       smpptest.DefaultPasswd,
+		RespTimeout:  100 * time.Millisecond,
+		TrackUserRef: true,
+		Handler:      func(p pdu.Body) { unsolicited <- p },
+	}
+	defer tc.Close()
+	conn := <-tc.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	newSM := func(ref uint16) *ShortMessage {
+		sm := &ShortMessage{
+			Src:       "root",
+			Dst:       "foobar",
+			Text:      pdutext.Raw("Lorem ipsum"),
+			TLVFields: make(pdufield.TLVMap),
+		}
+		sm.TLVFields.SetUserMessageReference(ref)
+		return sm
+	}
+
+	// The first attempt times out and is retried, its late
+	// response must not reach the handler.
+	if _, err := tc.Submit(newSM(1)); err == nil {
+		t.Fatal("unexpected response for the first attempt")
+	}
+	sm, err := tc.Submit(newSM(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := sm.RespID(); id != "attempt-2" {
+		t.Fatalf("unexpected message id: want attempt-2, have %q", id)
+	}
+	select {
+	case p := <-unsolicited:
+		t.Fatalf("unexpected PDU for handler: %s %s", p.Header().ID, p.Fields()[pdufield.MessageID])
+	case <-time.After(400 * time.Millisecond):
+	}
+
+	// An attempt still in flight is superseded by the retry.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tc.Submit(newSM(2))
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	sm, err = tc.Submit(newSM(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := sm.RespID(); id != "attempt-4" {
+		t.Fatalf("unexpected message id: want attempt-4, have %q", id)
+	}
+	if err := <-errc; err != ErrSuperseded {
+		t.Fatalf("unexpected error: want ErrSuperseded, have %v", err)
+	}
+}
+
+func TestMessageIDNormalizer(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "0A1B2C3D")
+			c.Write(r)
+			r = pdu.NewDeliverSM()
+			f := r.Fields()
+			f.Set(pdufield.SourceAddr, "foobar")
+			f.Set(pdufield.DestinationAddr, "root")
+			f.Set(pdufield.ESMClass, 0x04)
+			f.Set(pdufield.ShortMessage, "id:0a1b2c3d sub:001 dlvrd:001 submit date:1510201200 done date:1510201201 stat:DELIVRD err:000 text:Lorem")
+			r.TLVFields().Set(pdufield.ReceiptedMessageID, "0a1B2c3D\x00")
+			c.Write(r)
+			r = pdu.NewDeliverSM()
+			f = r.Fields()
+			f.Set(pdufield.SourceAddr, "foobar")
+			f.Set(pdufield.DestinationAddr, "root")
+			f.Set(pdufield.ShortMessage, "id:ABC is MY Code")
+			c.Write(r)
+		case pdu.DeliverSMRespID:
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	tc := &Transceiver{
+		Addr:                s.Addr(),
+		User:                smpptest.DefaultUser,
+		Passwd:              smpptest.DefaultPasswd,
+		Handler:             func(p pdu.Body) { rc <- p },
+		MessageIDNormalizer: strings.ToLower,
+	}
+	defer tc.Close()
+	conn := <-tc.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	sm, err := tc.Submit(&ShortMessage{
+		Src:      "root",
+		Dst:      "foobar",
+		Text:     pdutext.Raw("Lorem"),
+		Register: pdufield.FinalDeliveryReceipt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := sm.RespID(); id != "0a1b2c3d" {
+		t.Fatalf("unexpected message_id: want %q, have %q", "0a1b2c3d", id)
+	}
+	var p pdu.Body
+	select {
+	case p = <-rc:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for delivery receipt")
+	}
+	dr, err := ParseDeliveryReport(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.MessageID != sm.RespID() {
+		t.Fatalf("unexpected receipted_message_id: want %q, have %q", sm.RespID(), dr.MessageID)
+	}
+	if dr.Receipt.ID != sm.RespID() {
+		t.Fatalf("unexpected receipt id: want %q, have %q", sm.RespID(), dr.Receipt.ID)
+	}
+	if text := dr.Receipt.Text; text != "Lorem" {
+		t.Fatalf("unexpected receipt text: want %q, have %q", "Lorem", text)
+	}
+	// Text of other deliver_sm is not rewritten.
+	select {
+	case p = <-rc:
+		if text := p.Fields()[pdufield.ShortMessage].String(); text != "id:ABC is MY Code" {
+			t.Fatalf("unexpected short_message: want %q, have %q", "id:ABC is MY Code", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for deliver_sm")
+	}
+}
+
+func TestTransceiverDataSMReceipt(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = smpptest.ReceiptHandler(smpptest.ReceiptDataSM)
+	s.Start()
+	defer s.Close()
+	rc := make(chan pdu.Body, 1)
+	tc := &Transceiver{
+		Addr:    s.Addr(),
+		User:    smpptest.DefaultUser,
+		Passwd:  smpptest.DefaultPasswd,
+		Handler: func(p pdu.Body) { rc <- p },
+	}
+	defer tc.Close()
+	conn := <-tc.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	sm, err := tc.Submit(&ShortMessage{
+		Src:      "root",
+		Dst:      "foobar",
+		Text:     pdutext.Raw("Lorem ipsum"),
+		Register: pdufield.FinalDeliveryReceipt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p pdu.Body
+	select {
+	case p = <-rc:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for delivery receipt")
+	}
+	if p.Header().ID != pdu.DataSMID {
+		t.Fatalf("unexpected PDU: want DataSM, have %s", p.Header().ID)
+	}
+	dr, err := ParseDeliveryReport(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.MessageID != sm.RespID() {
+		t.Fatalf("unexpected receipted_message_id: want %q, have %q", sm.RespID(), dr.MessageID)
+	}
+	if dr.State != pdufield.StateDelivered {
+		t.Fatalf("unexpected message_state: want %s, have %s", pdufield.StateDelivered, dr.State)
+	}
+	if dr.Receipt == nil || dr.Receipt.ID != sm.RespID() || dr.Receipt.Text != "Lorem ipsum" {
+		t.Fatalf("unexpected receipt: %#v", dr.Receipt)
+	}
+}
@@ -5,12 +5,17 @@
 package smpp
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,25 +29,62 @@ import (
 // the maximum window size configured for the Transmitter or Transceiver.
 var ErrMaxWindowSize = errors.New("reached max window size")
 
+// ErrSuperseded is returned when TrackUserRef is set and a request is
+// superseded by another one with the same user_message_reference
+// before its response arrives.
+var ErrSuperseded = errors.New("superseded by request with same user_message_reference")
+
+// ErrNoText is returned on attempts to submit a ShortMessage with no
+// Text, unless it selects a canned message with SMDefaultMsgID.
+var ErrNoText = errors.New("short message has no text")
+
+// ErrShortMessageTooLong is returned by SubmitLongMsg with NoSegmentation
+// when the encoded text does not fit in short_message.
+var ErrShortMessageTooLong = errors.New("short message too long for sm_length")
+
+// staleRespTTL is how long the sequence numbers of abandoned requests
+// are remembered, to ignore their late responses.
+const staleRespTTL = time.Minute
+
 // MaxDestinationAddress is the maximum number of destination addresses allowed
 // in the submit_multi operation.
 const MaxDestinationAddress = 254
 
+// maxShortMessageLen is the maximum sm_length.
+const maxShortMessageLen = 254
+
 // Transmitter implements an SMPP client transmitter.
 type Transmitter struct {
-	Addr               string        // Server address in form of host:port.
-	User               string        // Username.
-	Passwd             string        // Password.
-	SystemType         string        // System type, default empty.
-	EnquireLink        time.Duration // Enquire link interval, default 10s.
-	EnquireLinkTimeout time.Duration // Time after last EnquireLink response when connection considered down
-	RespTimeout        time.Duration // Response timeout, default 1s.
-	BindInterval       time.Duration // Binding retry interval
-	TLS                *tls.Config   // TLS client settings, optional.
-	RateLimiter        RateLimiter   // Rate limiter, optional.
-	WindowSize         uint
-	rMutex             sync.Mutex
-	r                  *rand.Rand
+	Addr                 string                       // Server address in form of host:port.
+	Network              string                       // Network of Addr, tcp or unix for a socket path, default tcp.
+	User                 string                       // Username.
+	Passwd               string                       // Password.
+	SystemType           string                       // System type, default empty.
+	EnquireLink          time.Duration                // Enquire link interval, default 10s.
+	EnquireLinkTimeout   time.Duration                // Time after last EnquireLink response when connection considered down
+	CoalesceEnquireLink  bool                         // Send EnquireLink only when no PDU was sent for EnquireLink, responses count for EnquireLinkTimeout meanwhile, optional.
+	AnyPDUKeepAlive      bool                         // Count any received PDU, not only EnquireLinkResp, for EnquireLinkTimeout, optional.
+	SMLengthPolicy       *pdufield.SMLengthPolicy     // How to decode inbound PDUs whose sm_length disagrees with the short message, optional.
+	RespTimeout          time.Duration                // Response timeout, default 1s.
+	RetryOnReconnect     time.Duration                // Time a Submit may wait for a reconnect in progress, retrying once on connection errors, optional.
+	TrackUserRef         bool                         // Track submits by user_message_reference and ignore late responses of superseded attempts, optional.
+	OrderByDst           bool                         // Write requests to the same destination in the order they are made, optional.
+	DefaultTLVs          pdufield.TLVMap              // TLVs set on every submit that does not set them, optional.
+	OnBeforeSend         func(seq uint32, p pdu.Body) // Called with the sequence number of each request before it is written, optional.
+	MessageIDNormalizer  func(id string) string       // Rewrites message_id of responses and delivery receipts to a canonical form, optional.
+	DialTimeout          time.Duration                // Timeout for connecting to the server, optional.
+	LocalAddr            net.Addr                     // Local address to connect from, optional.
+	BindTimeout          time.Duration                // Timeout for the bind response, optional.
+	WriteTimeout         time.Duration                // Timeout for writing a PDU, after which the connection is closed, optional.
+	BindInterval         time.Duration                // Binding retry interval
+	AlreadyBoundInterval time.Duration                // Binding retry interval after ESME_RALYBND, optional.
+	BindLimit            *BindLimit                   // Limit of concurrent sessions, shared with the clients given the same one, optional.
+	TLS                  *tls.Config                  // TLS client settings, optional.
+	BindTracer           func(bt *BindTrace)          // Called with the details of each bind attempt, optional.
+	RateLimiter          RateLimiter                  // Rate limiter, optional.
+	WindowSize           uint
+	rMutex               sync.Mutex
+	r                    *rand.Rand
 
 	cl struct {
 		sync.Mutex
@@ -53,6 +95,16 @@ type Transmitter struct {
 		count int32
 		sync.Mutex
 		inflight map[uint32]chan *tx
+		pending  map[uint32]PendingInfo
+		// Only used with TrackUserRef.
+		refs  map[uint16]uint32    // user_message_reference to seq of the latest request
+		stale map[uint32]time.Time // seq of abandoned requests
+	}
+
+	// Only used with OrderByDst, last request queued per destination.
+	dst struct {
+		sync.Mutex
+		tail map[string]chan struct{}
 	}
 }
 
@@ -74,18 +126,38 @@ func (t *Transmitter) Bind() <-chan ConnStatus {
 	}
 	t.tx.Lock()
 	t.tx.inflight = make(map[uint32]chan *tx)
+	t.tx.pending = make(map[uint32]PendingInfo)
+	t.tx.refs = make(map[uint16]uint32)
+	t.tx.stale = make(map[uint32]time.Time)
 	t.tx.Unlock()
 	c := &client{
-		Addr:               t.Addr,
-		TLS:                t.TLS,
-		Status:             make(chan ConnStatus, 1),
-		BindFunc:           t.bindFunc,
-		EnquireLink:        t.EnquireLink,
-		EnquireLinkTimeout: t.EnquireLinkTimeout,
-		RespTimeout:        t.RespTimeout,
-		WindowSize:         t.WindowSize,
-		RateLimiter:        t.RateLimiter,
-		BindInterval:       t.BindInterval,
+		Addr:                 t.Addr,
+		Network:              t.Network,
+		TLS:                  t.TLS,
+		Status:               make(chan ConnStatus, 1),
+		BindFunc:             t.bindFunc,
+		EnquireLink:          t.EnquireLink,
+		EnquireLinkTimeout:   t.EnquireLinkTimeout,
+		CoalesceEnquireLink:  t.CoalesceEnquireLink,
+		AnyPDUKeepAlive:      t.AnyPDUKeepAlive,
+		SMLengthPolicy:       t.SMLengthPolicy,
+		RespTimeout:          t.RespTimeout,
+		RetryOnReconnect:     t.RetryOnReconnect,
+		TrackUserRef:         t.TrackUserRef,
+		OrderByDst:           t.OrderByDst,
+		DefaultTLVs:          t.DefaultTLVs,
+		OnBeforeSend:         t.OnBeforeSend,
+		MessageIDNormalizer:  t.MessageIDNormalizer,
+		WindowSize:           t.WindowSize,
+		RateLimiter:          t.RateLimiter,
+		DialTimeout:          t.DialTimeout,
+		LocalAddr:            t.LocalAddr,
+		BindTimeout:          t.BindTimeout,
+		WriteTimeout:         t.WriteTimeout,
+		BindInterval:         t.BindInterval,
+		AlreadyBoundInterval: t.AlreadyBoundInterval,
+		BindLimit:            t.BindLimit,
+		BindTracer:           t.BindTracer,
 	}
 	t.cl.client = c
 	c.init()
@@ -99,7 +171,7 @@ func (t *Transmitter) bindFunc(c Conn) error {
 	f.Set(pdufield.SystemID, t.User)
 	f.Set(pdufield.Password, t.Passwd)
 	f.Set(pdufield.SystemType, t.SystemType)
-	resp, err := bind(c, p)
+	resp, err := bind(c, p, t.cl.BindTracer)
 	if err != nil {
 		return err
 	}
@@ -121,24 +193,104 @@ func (t *Transmitter) handlePDU(f HandlerFunc) {
 		seq := p.Header().Seq
 		t.tx.Lock()
 		rc := t.tx.inflight[seq]
+		if rc != nil && !isResp(p) {
+			// Match responses on the sequence number, some SMSCs
+			// send them with the command_id of the request.
+			if t.tx.pending[seq].ID != p.Header().ID {
+				rc = nil
+			} else if resp, err := swappedResp(p); err != nil {
+				rc = nil
+			} else {
+				atomic.AddUint64(&swappedResps, 1)
+				p = resp
+			}
+		}
+		_, stale := t.tx.stale[seq]
+		if stale && isResp(p) {
+			delete(t.tx.stale, seq)
+		}
 		t.tx.Unlock()
 		if rc != nil {
 			rc <- &tx{PDU: p}
+		} else if stale && isResp(p) {
+			// Late response of an abandoned request, ignore it.
 		} else if f != nil {
 			f(p)
 		}
-		if p.Header().ID == pdu.DeliverSMID { // Send DeliverSMResp
+		switch p.Header().ID {
+		case pdu.DeliverSMID: // Send DeliverSMResp
 			pResp := pdu.NewDeliverSMRespSeq(p.Header().Seq)
 			t.cl.Write(pResp)
+		case pdu.DataSMID: // Send DataSMResp
+			pResp := pdu.NewDataSMRespSeq(p.Header().Seq)
+			t.cl.Write(pResp)
 		}
 	}
+	t.failInflight(ErrNotConnected)
+}
+
+// failInflight fails the requests awaiting their response with err.
+func (t *Transmitter) failInflight(err error) {
 	t.tx.Lock()
 	for _, rc := range t.tx.inflight {
-		rc <- &tx{Err: ErrNotConnected}
+		select {
+		case rc <- &tx{Err: err}:
+		default: // Already answered
+		}
 	}
 	t.tx.Unlock()
 }
 
+// Ping sends an EnquireLink and waits for its response, returning
+// the round-trip time, or ctx.Err() if ctx is done first.
+func (t *Transmitter) Ping(ctx context.Context) (time.Duration, error) {
+	t.cl.Lock()
+	c := t.cl.client
+	t.cl.Unlock()
+	if c == nil {
+		return 0, ErrNotBound
+	}
+	return c.Ping(ctx)
+}
+
+// CongestionState returns the congestion_state last reported by the
+// SMSC in enquire_link_resp, from 0 (idle) to 100 (congested), and
+// whether one was reported on the current connection.
+func (t *Transmitter) CongestionState() (uint8, bool) {
+	t.cl.Lock()
+	defer t.cl.Unlock()
+	if t.cl.client == nil {
+		return 0, false
+	}
+	return t.cl.congestionState()
+}
+
+// isResp returns whether p is a response PDU.
+func isResp(p pdu.Body) bool {
+	return p.Header().ID&0x80000000 != 0
+}
+
+var swappedResps uint64
+
+// SwappedResps returns the number of responses received with the
+// command_id of their request instead, e.g. submit_sm for
+// submit_sm_resp, since the program started. They are matched to
+// their request by sequence number, and decoded as the response.
+func SwappedResps() uint64 {
+	return atomic.LoadUint64(&swappedResps)
+}
+
+// swappedResp decodes p, a response received with the command_id of
+// its request, as the response.
+func swappedResp(p pdu.Body) (pdu.Body, error) {
+	b := append([]byte{}, pdu.Raw(p)...)
+	if len(b) < pdu.HeaderLen {
+		return nil, errors.New("raw PDU not available")
+	}
+	binary.BigEndian.PutUint32(b[4:8], uint32(p.Header().ID)|0x80000000)
+	return pdu.Decode(bytes.NewReader(b))
+}
+
 // Close implements the ClientConn interface.
 func (t *Transmitter) Close() error {
 	t.cl.Lock()
@@ -191,15 +343,113 @@ type ShortMessage struct {
 	PriorityFlag         uint8
 	ScheduleDeliveryTime string
 	ReplaceIfPresentFlag uint8
-	SMDefaultMsgID       uint8
+	SMDefaultMsgID       uint8 // Canned message of the SMSC, sent with no Text if nonzero.
 	NumberDests          uint8
 
+	// Optional TLV fields, e.g. user_response_code.
+	TLVFields pdufield.TLVMap
+
+	// SegmentDataCoding overrides the data_coding of the segments
+	// sent by SubmitLongMsg, regardless of the Text codec, which is
+	// still used to encode and split the text. This is a knob for
+	// carrier quirks, e.g. an SMSC that requires 0xF6 instead of 0x08
+	// on concatenated UCS2 segments. Optional.
+	SegmentDataCoding *uint8
+
+	// NoSegmentation makes SubmitLongMsg send the encoded text as is
+	// in a single submit_sm, without splitting it or adding a user
+	// data header, whatever its length. Meant for testing SMSCs and
+	// for carriers that accept long single PDUs; the text must still
+	// fit the 254 octets of short_message, or ErrShortMessageTooLong
+	// is returned. Optional.
+	NoSegmentation bool
+
+	// Encoded is text already encoded upstream, sent as is with
+	// DataCoding, bypassing the Text codec, which may be nil. This is a
+	// performance knob for bulk senders with their own encoder. It is
+	// split as is by SubmitLongMsg, so must not break multi-octet
+	// characters at segment boundaries. Optional.
+	Encoded    []byte
+	DataCoding pdutext.DataCoding
+
+	// RawAddr sends Src and Dst as is. By default the leading + of
+	// addresses with international TON is stripped, as per the spec.
+	RawAddr bool
+
+	// DuplicatePayload sends the text in the message_payload TLV as
+	// well as in short_message, truncated to 254 octets if needed.
+	// This is not standard, the spec forbids using both at once, and
+	// is only meant for SMSCs that read message_payload exclusively
+	// but reject a zero sm_length. Ignored by SubmitLongMsg. Optional.
+	DuplicatePayload bool
+
 	resp struct {
 		sync.Mutex
 		p pdu.Body
 	}
 }
 
+// text returns the codec of the text, see Encoded.
+func (sm *ShortMessage) text() pdutext.Codec {
+	if sm.Encoded != nil {
+		return encodedText{data: sm.Encoded, coding: sm.DataCoding}
+	}
+	if sm.Text == nil {
+		// Canned message, see SMDefaultMsgID.
+		return pdutext.Raw(nil)
+	}
+	return sm.Text
+}
+
+// checkText returns ErrNoText if sm has no text, see SMDefaultMsgID.
+func (sm *ShortMessage) checkText() error {
+	if sm.Text == nil && sm.Encoded == nil && sm.SMDefaultMsgID == 0 {
+		return ErrNoText
+	}
+	return nil
+}
+
+// encodedText is a text codec for pre-encoded text.
+type encodedText struct {
+	data   []byte
+	coding pdutext.DataCoding
+}
+
+// Type implements the pdutext.Codec interface.
+func (t encodedText) Type() pdutext.DataCoding {
+	return t.coding
+}
+
+// Encode implements the pdutext.Codec interface.
+func (t encodedText) Encode() []byte {
+	return t.data
+}
+
+// Decode implements the pdutext.Codec interface.
+func (t encodedText) Decode() []byte {
+	return t.data
+}
+
+// addr returns addr as sent with the given TON, see RawAddr.
+func (sm *ShortMessage) addr(addr string, ton uint8) string {
+	if sm.RawAddr {
+		return addr
+	}
+	return formatAddr(addr, ton)
+}
+
+// tonInternational is the international type of number.
+const tonInternational = 0x01
+
+// formatAddr strips the leading + of international addresses, which
+// must be sent as digits only.
+func formatAddr(addr string, ton uint8) string {
+	if ton == tonInternational {
+		return strings.TrimPrefix(addr, "+")
+	}
+	return addr
+}
+
 // Resp returns the response PDU, or nil if not set.
 func (sm *ShortMessage) Resp() pdu.Body {
 	sm.resp.Lock()
@@ -268,6 +518,54 @@ func (sm *ShortMessage) UnsuccessSmes() ([]UnsucessDest, error) {
 	return nil, errors.New("Cannot convert PDU field to UnSmeList")
 }
 
+// RespDetails contains the message_id and delivery details of the
+// response of submit_sm, submit_multi or data_sm. The TLVs are only
+// set when present; data_sm_resp is the one expected to carry them,
+// but some SMSCs send them on submit_sm_resp as well.
+type RespDetails struct {
+	MessageID string
+
+	State                 *pdufield.State        // message_state.
+	NetworkError          *pdufield.NetworkError // network_error_code.
+	DeliveryFailureReason *uint8                 // delivery_failure_reason.
+	DpfResult             *uint8                 // dpf_result.
+	AdditionalStatusInfo  string                 // additional_status_info_text.
+}
+
+// ParseRespDetails returns the details of p, a submit_sm_resp,
+// submit_multi_resp or data_sm_resp.
+func ParseRespDetails(p pdu.Body) (*RespDetails, error) {
+	switch id := p.Header().ID; id {
+	case pdu.SubmitSMRespID, pdu.SubmitMultiRespID, pdu.DataSMRespID:
+	default:
+		return nil, fmt.Errorf("unexpected PDU ID: %s", id)
+	}
+	rd := &RespDetails{}
+	if f := p.Fields()[pdufield.MessageID]; f != nil {
+		rd.MessageID = f.String()
+	}
+	tlv := p.TLVFields()
+	if state, ok := tlv.MessageState(); ok {
+		rd.State = &state
+	}
+	rd.NetworkError, rd.DeliveryFailureReason, rd.DpfResult = deliveryDetails(tlv)
+	if v, ok := tlv[pdufield.AdditionalStatusInfoText]; ok {
+		// C-Octet String, drop the terminator if present.
+		rd.AdditionalStatusInfo = strings.TrimRight(string(v.Bytes()), "\x00")
+	}
+	return rd, nil
+}
+
+// RespDetails is a shortcut to ParseRespDetails(Resp()). Returns an
+// error if the response PDU is not available.
+func (sm *ShortMessage) RespDetails() (*RespDetails, error) {
+	p := sm.Resp()
+	if p == nil {
+		return nil, errors.New("Response PDU not available")
+	}
+	return ParseRespDetails(p)
+}
+
 func (t *Transmitter) do(p pdu.Body) (*tx, error) {
 	t.cl.Lock()
 	notbound := t.cl.client == nil
@@ -282,17 +580,64 @@ func (t *Transmitter) do(p pdu.Body) (*tx, error) {
 			return nil, ErrMaxWindowSize
 		}
 	}
+	if len(t.cl.DefaultTLVs) > 0 {
+		setDefaultTLVs(p, t.cl.DefaultTLVs)
+	}
+	var ref uint16
+	var tracked bool
+	if t.cl.TrackUserRef {
+		ref, tracked = p.TLVFields().UserMessageReference()
+	}
 	rc := make(chan *tx, 1)
-	seq := p.Header().Seq
 	t.tx.Lock()
+	seq := p.Header().Seq
+	for t.tx.inflight[seq] != nil {
+		// After wraparound the sequence number may still be in use
+		// by a long-lived request, skip it.
+		seq = pdu.NextSeq()
+	}
+	p.Header().Seq = seq
 	t.tx.inflight[seq] = rc
+	t.tx.pending[seq] = newPendingInfo(p, ref, tracked)
+	if tracked {
+		t.supersede(ref, seq)
+	}
 	t.tx.Unlock()
+	if t.cl.OnBeforeSend != nil {
+		// Once seq is reserved, and before the PDU leaves, so the
+		// caller may persist it first.
+		t.cl.OnBeforeSend(seq, p)
+	}
+	answered := false
 	defer func() {
 		t.tx.Lock()
-		delete(t.tx.inflight, seq)
+		if t.tx.inflight[seq] == rc {
+			delete(t.tx.inflight, seq)
+			delete(t.tx.pending, seq)
+		}
+		if tracked {
+			if t.tx.refs[ref] == seq {
+				delete(t.tx.refs, ref)
+			}
+			if !answered {
+				t.tx.stale[seq] = time.Now()
+			}
+		}
 		t.tx.Unlock()
 	}()
-	err := t.cl.Write(p)
+	var err error
+	if dst, ok := p.Fields()[pdufield.DestinationAddr]; ok && t.cl.OrderByDst {
+		done := t.queueDst(dst.String())
+		err = t.write(p)
+		done()
+	} else {
+		err = t.write(p)
+	}
+	if err == ErrWriteTimeout {
+		// The connection is closed, responses to the requests
+		// sent on it will not arrive.
+		t.failInflight(err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -301,37 +646,192 @@ func (t *Transmitter) do(p pdu.Body) (*tx, error) {
 		if resp.Err != nil {
 			return nil, resp.Err
 		}
+		answered = true
 		return resp, nil
 	case <-t.cl.respTimeout():
 		return nil, errors.New("timeout waiting for response")
 	}
 }
 
+// queueDst waits for the requests to dst queued before, and returns
+// the function to call once the request is written, letting the next
+// one through. Unlike a mutex, it lets requests through in the order
+// they are queued.
+func (t *Transmitter) queueDst(dst string) (done func()) {
+	next := make(chan struct{})
+	t.dst.Lock()
+	if t.dst.tail == nil {
+		t.dst.tail = make(map[string]chan struct{})
+	}
+	prev := t.dst.tail[dst]
+	t.dst.tail[dst] = next
+	t.dst.Unlock()
+	if prev != nil {
+		<-prev
+	}
+	return func() {
+		t.dst.Lock()
+		if t.dst.tail[dst] == next {
+			delete(t.dst.tail, dst)
+		}
+		t.dst.Unlock()
+		close(next)
+	}
+}
+
+// write writes p to the connection. With RetryOnReconnect, it waits
+// for a reconnect in progress to complete first, and retries once on
+// the new connection if the write fails because the connection is gone.
+func (t *Transmitter) write(p pdu.Body) error {
+	wait := t.cl.RetryOnReconnect
+	if wait == 0 {
+		return t.cl.Write(p)
+	}
+	deadline := time.Now().Add(wait)
+	n, bound := t.cl.bindState()
+	if !bound {
+		if err := t.cl.waitBind(n, wait); err != nil {
+			return err
+		}
+		n, _ = t.cl.bindState()
+	}
+	err := t.cl.Write(p)
+	if err == nil || !isConnErr(err) {
+		return err
+	}
+	if t.cl.waitBind(n, deadline.Sub(time.Now())) != nil {
+		return err
+	}
+	return t.cl.Write(p)
+}
+
+// PendingInfo describes a request awaiting its response.
+type PendingInfo struct {
+	Seq        uint32        // Sequence number.
+	ID         pdu.ID        // Command ID, e.g. SubmitSMID.
+	Dst        string        // Destination address, if any.
+	UserRef    uint16        // user_message_reference, if HasUserRef.
+	HasUserRef bool          // Whether the request has user_message_reference.
+	Sent       time.Time     // When the request was sent.
+	Age        time.Duration // Time since the request was sent, when the snapshot was taken.
+}
+
+func newPendingInfo(p pdu.Body, ref uint16, tracked bool) PendingInfo {
+	pi := PendingInfo{
+		Seq:  p.Header().Seq,
+		ID:   p.Header().ID,
+		Sent: time.Now(),
+	}
+	if dst, ok := p.Fields()[pdufield.DestinationAddr]; ok {
+		pi.Dst = dst.String()
+	}
+	if tracked {
+		pi.UserRef, pi.HasUserRef = ref, true
+	} else {
+		pi.UserRef, pi.HasUserRef = p.TLVFields().UserMessageReference()
+	}
+	return pi
+}
+
+// PendingRequests returns a snapshot of the requests awaiting their
+// response, oldest first.
+func (t *Transmitter) PendingRequests() []PendingInfo {
+	t.tx.Lock()
+	list := make([]PendingInfo, 0, len(t.tx.pending))
+	for _, pi := range t.tx.pending {
+		list = append(list, pi)
+	}
+	t.tx.Unlock()
+	now := time.Now()
+	for i := range list {
+		list[i].Age = now.Sub(list[i].Sent)
+	}
+	sort.Sort(pendingByAge(list))
+	return list
+}
+
+type pendingByAge []PendingInfo
+
+func (l pendingByAge) Len() int           { return len(l) }
+func (l pendingByAge) Less(i, j int) bool { return l[i].Sent.Before(l[j].Sent) }
+func (l pendingByAge) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// supersede registers seq as the latest request with the given
+// user_message_reference, failing the previous one with ErrSuperseded.
+// It must be called with t.tx locked.
+func (t *Transmitter) supersede(ref uint16, seq uint32) {
+	delete(t.tx.stale, seq)
+	for s, ts := range t.tx.stale {
+		if time.Since(ts) > staleRespTTL {
+			delete(t.tx.stale, s)
+		}
+	}
+	if prev, ok := t.tx.refs[ref]; ok {
+		if rc := t.tx.inflight[prev]; rc != nil {
+			delete(t.tx.inflight, prev)
+			delete(t.tx.pending, prev)
+			select {
+			case rc <- &tx{Err: ErrSuperseded}:
+			default:
+			}
+		}
+	}
+	t.tx.refs[ref] = seq
+}
+
+// setDefaultTLVs sets the TLVs of tlv not already set in p, if p is
+// a submit_sm, submit_multi or data_sm.
+func setDefaultTLVs(p pdu.Body, tlv pdufield.TLVMap) {
+	switch p.Header().ID {
+	case pdu.SubmitSMID, pdu.SubmitMultiID, pdu.DataSMID:
+	default:
+		return
+	}
+	f := p.TLVFields()
+	for k, v := range tlv {
+		if _, ok := f[k]; !ok {
+			f[k] = v
+		}
+	}
+}
+
 // Submit sends a short message and returns and updates the given
 // sm with the response status. It returns the same sm object.
 func (t *Transmitter) Submit(sm *ShortMessage) (*ShortMessage, error) {
+	if err := sm.checkText(); err != nil {
+		return nil, err
+	}
 	if len(sm.DstList) > 0 || len(sm.DLs) > 0 {
 		// if we have a single destination address add it to the list
 		if sm.Dst != "" {
 			sm.DstList = append(sm.DstList, sm.Dst)
 		}
 		p := pdu.NewSubmitMulti()
-		return t.submitMsgMulti(sm, p, uint8(sm.Text.Type()))
+		return t.submitMsgMulti(sm, p, uint8(sm.text().Type()))
 	}
 	p := pdu.NewSubmitSM()
-	return t.submitMsg(sm, p, uint8(sm.Text.Type()))
+	return t.submitMsg(sm, p, uint8(sm.text().Type()))
 }
 
 // SubmitLongMsg sends a long message (more than 140 bytes)
 // and returns and updates the given sm with the response status.
 // It returns the same sm object.
 func (t *Transmitter) SubmitLongMsg(sm *ShortMessage) (*ShortMessage, error) {
-	maxLen := 133 // 140-7 (UDH with 2 byte reference number)
-	if sm.Text.Type() == pdutext.UCS2Type {
-		maxLen = 132 // to avoid a character being split between payloads
+	if err := sm.checkText(); err != nil {
+		return nil, err
+	}
+	if sm.NoSegmentation && len(sm.text().Encode()) > maxShortMessageLen {
+		return nil, ErrShortMessageTooLong
+	}
+	if sm.NoSegmentation || (sm.SMDefaultMsgID != 0 && pdutext.EncodedLen(sm.text()) == 0) {
+		return t.Submit(sm)
+	}
+	segments := splitSegments(sm.text(), sm.text().Encode(), Concat16)
+	countParts := len(segments)
+	dataCoding := uint8(sm.text().Type())
+	if sm.SegmentDataCoding != nil {
+		dataCoding = *sm.SegmentDataCoding
 	}
-	rawMsg := sm.Text.Encode()
-	countParts := int((len(rawMsg)-1)/maxLen) + 1
 
 	t.rMutex.Lock()
 	rn := uint16(t.r.Intn(0xFFFF))
@@ -347,13 +847,9 @@ func (t *Transmitter) SubmitLongMsg(sm *ShortMessage) (*ShortMessage, error) {
 		UDHHeader[6] = uint8(i + 1) // current message part
 		p := pdu.NewSubmitSM()
 		f := p.Fields()
-		f.Set(pdufield.SourceAddr, sm.Src)
-		f.Set(pdufield.DestinationAddr, sm.Dst)
-		if i != countParts-1 {
-			f.Set(pdufield.ShortMessage, pdutext.Raw(append(UDHHeader, rawMsg[i*maxLen:(i+1)*maxLen]...)))
-		} else {
-			f.Set(pdufield.ShortMessage, pdutext.Raw(append(UDHHeader, rawMsg[i*maxLen:]...)))
-		}
+		f.Set(pdufield.SourceAddr, sm.addr(sm.Src, sm.SourceAddrTON))
+		f.Set(pdufield.DestinationAddr, sm.addr(sm.Dst, sm.DestAddrTON))
+		f.Set(pdufield.ShortMessage, pdutext.Raw(append(UDHHeader, segments[i]...)))
 		f.Set(pdufield.RegisteredDelivery, uint8(sm.Register))
 		if sm.Validity != time.Duration(0) {
 			f.Set(pdufield.ValidityPeriod, convertValidity(sm.Validity))
@@ -369,7 +865,10 @@ func (t *Transmitter) SubmitLongMsg(sm *ShortMessage) (*ShortMessage, error) {
 		f.Set(pdufield.ScheduleDeliveryTime, sm.ScheduleDeliveryTime)
 		f.Set(pdufield.ReplaceIfPresentFlag, sm.ReplaceIfPresentFlag)
 		f.Set(pdufield.SMDefaultMsgID, sm.SMDefaultMsgID)
-		f.Set(pdufield.DataCoding, uint8(sm.Text.Type()))
+		f.Set(pdufield.DataCoding, dataCoding)
+		for k, v := range sm.TLVFields {
+			p.TLVFields()[k] = v
+		}
 		resp, err := t.do(p)
 		if err != nil {
 			return nil, err
@@ -392,9 +891,9 @@ func (t *Transmitter) SubmitLongMsg(sm *ShortMessage) (*ShortMessage, error) {
 
 func (t *Transmitter) submitMsg(sm *ShortMessage, p pdu.Body, dataCoding uint8) (*ShortMessage, error) {
 	f := p.Fields()
-	f.Set(pdufield.SourceAddr, sm.Src)
-	f.Set(pdufield.DestinationAddr, sm.Dst)
-	f.Set(pdufield.ShortMessage, sm.Text)
+	f.Set(pdufield.SourceAddr, sm.addr(sm.Src, sm.SourceAddrTON))
+	f.Set(pdufield.DestinationAddr, sm.addr(sm.Dst, sm.DestAddrTON))
+	f.Set(pdufield.ShortMessage, sm.text())
 	f.Set(pdufield.RegisteredDelivery, uint8(sm.Register))
 	// Check if the message has validity set.
 	if sm.Validity != time.Duration(0) {
@@ -412,6 +911,12 @@ func (t *Transmitter) submitMsg(sm *ShortMessage, p pdu.Body, dataCoding uint8)
 	f.Set(pdufield.ReplaceIfPresentFlag, sm.ReplaceIfPresentFlag)
 	f.Set(pdufield.SMDefaultMsgID, sm.SMDefaultMsgID)
 	f.Set(pdufield.DataCoding, dataCoding)
+	for k, v := range sm.TLVFields {
+		p.TLVFields()[k] = v
+	}
+	if sm.DuplicatePayload {
+		setDuplicatePayload(p, sm.text())
+	}
 	resp, err := t.do(p)
 	if err != nil {
 		return nil, err
@@ -428,6 +933,17 @@ func (t *Transmitter) submitMsg(sm *ShortMessage, p pdu.Body, dataCoding uint8)
 	return sm, resp.Err
 }
 
+// setDuplicatePayload sets the encoded text in both short_message and
+// message_payload of p, see ShortMessage.DuplicatePayload.
+func setDuplicatePayload(p pdu.Body, text pdutext.Codec) {
+	b := text.Encode()
+	p.TLVFields().Set(pdufield.MessagePayload, b)
+	if len(b) > maxShortMessageLen {
+		b = b[:maxShortMessageLen]
+	}
+	p.Fields().Set(pdufield.ShortMessage, b)
+}
+
 func (t *Transmitter) submitMsgMulti(sm *ShortMessage, p pdu.Body, dataCoding uint8) (*ShortMessage, error) {
 	numberOfDest := len(sm.DstList) + len(sm.DLs) // TODO: Validate numbers and lists according to size
 	if numberOfDest > MaxDestinationAddress {
@@ -442,7 +958,7 @@ func (t *Transmitter) submitMsgMulti(sm *ShortMessage, p pdu.Body, dataCoding ui
 		bArray = append(bArray, byte(0x01))
 		bArray = append(bArray, byte(sm.DestAddrTON))
 		bArray = append(bArray, byte(sm.DestAddrNPI))
-		bArray = append(bArray, []byte(destAddr)...)
+		bArray = append(bArray, []byte(sm.addr(destAddr, sm.DestAddrTON))...)
 		// null terminator
 		bArray = append(bArray, byte(0x00))
 	}
@@ -457,9 +973,9 @@ func (t *Transmitter) submitMsgMulti(sm *ShortMessage, p pdu.Body, dataCoding ui
 	}
 
 	f := p.Fields()
-	f.Set(pdufield.SourceAddr, sm.Src)
+	f.Set(pdufield.SourceAddr, sm.addr(sm.Src, sm.SourceAddrTON))
 	f.Set(pdufield.DestinationList, bArray)
-	f.Set(pdufield.ShortMessage, sm.Text)
+	f.Set(pdufield.ShortMessage, sm.text())
 	f.Set(pdufield.NumberDests, uint8(numberOfDest))
 	f.Set(pdufield.RegisteredDelivery, uint8(sm.Register))
 	// Check if the message has validity set.
@@ -476,6 +992,12 @@ func (t *Transmitter) submitMsgMulti(sm *ShortMessage, p pdu.Body, dataCoding ui
 	f.Set(pdufield.ReplaceIfPresentFlag, sm.ReplaceIfPresentFlag)
 	f.Set(pdufield.SMDefaultMsgID, sm.SMDefaultMsgID)
 	f.Set(pdufield.DataCoding, dataCoding)
+	for k, v := range sm.TLVFields {
+		p.TLVFields()[k] = v
+	}
+	if sm.DuplicatePayload {
+		setDuplicatePayload(p, sm.text())
+	}
 	resp, err := t.do(p)
 	if err != nil {
 		return nil, err
@@ -506,7 +1028,7 @@ type QueryResp struct {
 func (t *Transmitter) QuerySM(src, msgid string, srcTON, srcNPI uint8) (*QueryResp, error) {
 	p := pdu.NewQuerySM()
 	f := p.Fields()
-	f.Set(pdufield.SourceAddr, src)
+	f.Set(pdufield.SourceAddr, formatAddr(src, srcTON))
 	f.Set(pdufield.SourceAddrTON, srcTON)
 	f.Set(pdufield.SourceAddrNPI, srcNPI)
 	f.Set(pdufield.MessageID, msgid)
@@ -561,7 +1083,5 @@ func (t *Transmitter) QuerySM(src, msgid string, srcTON, srcNPI uint8) (*QueryRe
 }
 
 func convertValidity(d time.Duration) string {
-	validity := time.Now().UTC().Add(d)
-	// Absolute time format YYMMDDhhmmsstnnp, see SMPP3.4 spec 7.1.1.
-	return validity.Format("060102150405") + "000+"
+	return FormatSMPPTime(time.Now().UTC().Add(d))
 }
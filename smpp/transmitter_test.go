@@ -5,6 +5,18 @@
 package smpp
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -370,3 +382,1822 @@ func TestNotConnected(t *testing.T) {
 	}
 
 }
+
+func TestCoalesceEnquireLink(t *testing.T) {
+	defer func(d time.Duration) { minEnquireLink = d }(minEnquireLink)
+	minEnquireLink = 10 * time.Millisecond
+	var eli int32
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.EnquireLinkID:
+			atomic.AddInt32(&eli, 1)
+			c.Write(pdu.NewEnquireLinkRespSeq(p.Header().Seq))
+		case pdu.SubmitSMID:
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tx := &Transmitter{
+		Addr:                s.Addr(),
+		User:                smpptest.DefaultUser,
+		Passwd:              smpptest.DefaultPasswd,
+		EnquireLink:         100 * time.Millisecond,
+		CoalesceEnquireLink: true,
+	}
+	defer tx.Close()
+	conn := <-tx.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	for i := 0; i < 25; i++ {
+		_, err := tx.Submit(&ShortMessage{
+			Src:      "root",
+			Dst:      "foobar",
+			Text:     pdutext.Raw("Lorem ipsum"),
+			Register: pdufield.NoDeliveryReceipt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&eli); n != 0 {
+		t.Fatalf("unexpected enquire_link while submitting: want 0, have %d", n)
+	}
+	time.Sleep(350 * time.Millisecond)
+	if n := atomic.LoadInt32(&eli); n == 0 {
+		t.Fatal("missing enquire_link after idle")
+	}
+}
+
+func TestCoalesceEnquireLinkTimeout(t *testing.T) {
+	defer func(d time.Duration) { minEnquireLink = d }(minEnquireLink)
+	minEnquireLink = 10 * time.Millisecond
+	var mute int32
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		if atomic.LoadInt32(&mute) == 1 {
+			return
+		}
+		switch p.Header().ID {
+		case pdu.EnquireLinkID:
+			c.Write(pdu.NewEnquireLinkRespSeq(p.Header().Seq))
+		case pdu.SubmitSMID:
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tx := &Transmitter{
+		Addr:                s.Addr(),
+		User:                smpptest.DefaultUser,
+		Passwd:              smpptest.DefaultPasswd,
+		EnquireLink:         100 * time.Millisecond,
+		EnquireLinkTimeout:  300 * time.Millisecond,
+		CoalesceEnquireLink: true,
+		RespTimeout:         20 * time.Millisecond,
+		BindInterval:        time.Second,
+	}
+	defer tx.Close()
+	status := tx.Bind()
+	conn := <-status
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	// The peer stops answering, while submits keep flowing.
+	atomic.StoreInt32(&mute, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			tx.Submit(&ShortMessage{
+				Src:      "root",
+				Dst:      "foobar",
+				Text:     pdutext.Raw("Lorem ipsum"),
+				Register: pdufield.NoDeliveryReceipt,
+			})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case conn = <-status:
+		if conn.Status() != Disconnected {
+			t.Fatalf("unexpected status: want Disconnected, have %s", conn.Status())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("link not declared dead while the peer does not answer")
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	s := smpptest.NewServer()
+	defer s.Close()
+	tx := &Transmitter{
+		Addr:        s.Addr(),
+		User:        smpptest.DefaultUser,
+		Passwd:      smpptest.DefaultPasswd,
+		DialTimeout: time.Nanosecond,
+		BindTimeout: time.Second,
+	}
+	defer tx.Close()
+	conn := <-tx.Bind()
+	if conn.Status() != ConnectionFailed {
+		t.Fatalf("unexpected status: want ConnectionFailed, have %s", conn.Status())
+	}
+	if conn.Error() != ErrDialTimeout {
+		t.Fatalf("unexpected error: want ErrDialTimeout, have %v", conn.Error())
+	}
+}
+
+func TestBindTimeout(t *testing.T) {
+	// Accept connections but never reply to bind.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	tx := &Transmitter{
+		Addr:        l.Addr().String(),
+		User:        smpptest.DefaultUser,
+		Passwd:      smpptest.DefaultPasswd,
+		DialTimeout: time.Second,
+		BindTimeout: 100 * time.Millisecond,
+	}
+	defer tx.Close()
+	select {
+	case conn := <-tx.Bind():
+		if conn.Status() != BindFailed {
+			t.Fatalf("unexpected status: want BindFailed, have %s", conn.Status())
+		}
+		if conn.Error() != ErrBindTimeout {
+			t.Fatalf("unexpected error: want ErrBindTimeout, have %v", conn.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for bind to time out")
+	}
+}
+
+func TestBindTrace(t *testing.T) {
+	s := smpptest.NewServer()
+	defer s.Close()
+	btc := make(chan *BindTrace, 1)
+	tx := &Transmitter{
+		Addr:       s.Addr(),
+		User:       smpptest.DefaultUser,
+		Passwd:     smpptest.DefaultPasswd,
+		SystemType: "test",
+		BindTracer: func(bt *BindTrace) { btc <- bt },
+	}
+	defer tx.Close()
+	conn := <-tx.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	bt := <-btc
+	if bt.SystemID != smpptest.DefaultUser {
+		t.Fatalf("unexpected system_id: want %q, have %q", smpptest.DefaultUser, bt.SystemID)
+	}
+	if bt.SystemType != "test" {
+		t.Fatalf("unexpected system_type: want test, have %q", bt.SystemType)
+	}
+	if bt.InterfaceVersion != 0x34 {
+		t.Fatalf("unexpected interface_version: want 0x34, have %#x", bt.InterfaceVersion)
+	}
+	if bytes.Contains(bt.Req, []byte(smpptest.DefaultPasswd)) {
+		t.Fatalf("password not redacted: %q", bt.Req)
+	}
+	req, err := pdu.Decode(bytes.NewBuffer(bt.Req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := req.Header().ID; id != pdu.BindTransmitterID {
+		t.Fatalf("unexpected bind PDU: %s", id)
+	}
+	if pw := req.Fields()[pdufield.Password].String(); pw != "******" {
+		t.Fatalf("unexpected redacted password: %q", pw)
+	}
+	resp, err := pdu.Decode(bytes.NewBuffer(bt.Resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := resp.Header().ID; id != pdu.BindTransmitterRespID {
+		t.Fatalf("unexpected bind resp PDU: %s", id)
+	}
+	if id := resp.Fields()[pdufield.SystemID].String(); id != smpptest.DefaultSystemID {
+		t.Fatalf("unexpected resp system_id: want %q, have %q", smpptest.DefaultSystemID, id)
+	}
+	if bt.Status != 0 || bt.Err != nil {
+		t.Fatalf("unexpected bind status: %v, %v", bt.Status, bt.Err)
+	}
+}
+
+func TestSeqSkipInflight(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		r := pdu.NewSubmitSMResp()
+		r.Header().Seq = p.Header().Seq
+		r.Fields().Set(pdufield.MessageID, "foobar")
+		c.Write(r)
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	// Simulate a wrapped around sequence number that is still
+	// held by an outstanding request.
+	p := pdu.NewSubmitSM()
+	seq := p.Header().Seq
+	tr.tx.Lock()
+	tr.tx.inflight[seq] = make(chan *tx, 1)
+	tr.tx.Unlock()
+	defer func() {
+		tr.tx.Lock()
+		delete(tr.tx.inflight, seq)
+		tr.tx.Unlock()
+	}()
+	resp, err := tr.do(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header().Seq == seq {
+		t.Fatalf("in-flight seq %d was reused", seq)
+	}
+	if resp.PDU.Header().Seq != p.Header().Seq {
+		t.Fatalf("unexpected resp seq: want %d, have %d",
+			p.Header().Seq, resp.PDU.Header().Seq)
+	}
+}
+
+func TestLongMessageSegmentDataCoding(t *testing.T) {
+	dcc := make(chan uint8, 10)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			dcc <- p.Fields()[pdufield.DataCoding].Raw().(uint8)
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tx := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tx.Close()
+	conn := <-tx.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	dc := uint8(0xF6)
+	_, err := tx.SubmitLongMsg(&ShortMessage{
+		Src:               "root",
+		Dst:               "foobar",
+		Text:              pdutext.UCS2("Lorem ipsum dolor sit amet, consectetur adipiscing elit. Nam consequat nisl enim, vel finibus neque aliquet sit amet. ✓"),
+		Register:          pdufield.NoDeliveryReceipt,
+		SegmentDataCoding: &dc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(dcc)
+	n := 0
+	for have := range dcc {
+		n++
+		if have != dc {
+			t.Fatalf("unexpected data_coding for segment %d: want %#x, have %#x", n, dc, have)
+		}
+	}
+	if n < 2 {
+		t.Fatalf("unexpected number of segments: want 2 or more, have %d", n)
+	}
+}
+
+func TestSubmitDuplicatePayload(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	for _, text := range []string{"Lorem ipsum", strings.Repeat("Lorem ipsum ", 30)} {
+		_, err := tr.Submit(&ShortMessage{
+			Src:              "root",
+			Dst:              "foobar",
+			Text:             pdutext.Raw(text),
+			Register:         pdufield.NoDeliveryReceipt,
+			DuplicatePayload: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := <-pc
+		payload := p.TLVFields()[pdufield.MessagePayload]
+		if payload == nil || string(payload.Bytes()) != text {
+			t.Fatalf("unexpected message_payload: want %q, have %#v", text, payload)
+		}
+		want := text
+		if len(want) > 254 {
+			want = want[:254]
+		}
+		if sm := p.Fields()[pdufield.ShortMessage].String(); sm != want {
+			t.Fatalf("unexpected short_message: want %q, have %q", want, sm)
+		}
+		if n := p.Fields()[pdufield.SMLength].Raw().(uint8); int(n) != len(want) {
+			t.Fatalf("unexpected sm_length: want %d, have %d", len(want), n)
+		}
+	}
+}
+
+func TestSubmitLongMsgNoSegmentation(t *testing.T) {
+	pc := make(chan pdu.Body, 10)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	text := strings.Repeat("0123456789", 20)
+	for _, raw := range []bool{false, true} {
+		_, err := tr.SubmitLongMsg(&ShortMessage{
+			Src:            "root",
+			Dst:            "foobar",
+			Text:           pdutext.Raw(text),
+			NoSegmentation: raw,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var segments []pdu.Body
+		for len(pc) > 0 {
+			segments = append(segments, <-pc)
+		}
+		if !raw {
+			if len(segments) != 2 {
+				t.Fatalf("unexpected number of segments: want 2, have %d", len(segments))
+			}
+			continue
+		}
+		if len(segments) != 1 {
+			t.Fatalf("unexpected number of submit_sm: want 1, have %d", len(segments))
+		}
+		f := segments[0].Fields()
+		if sm := f[pdufield.ShortMessage].String(); sm != text {
+			t.Fatalf("unexpected short_message: want %q, have %q", text, sm)
+		}
+		if esm := f[pdufield.ESMClass].Raw().(uint8); esm != 0 {
+			t.Fatalf("unexpected esm_class: want 0, have %#x", esm)
+		}
+	}
+	_, err := tr.SubmitLongMsg(&ShortMessage{
+		Src:            "root",
+		Dst:            "foobar",
+		Text:           pdutext.Raw(strings.Repeat("0123456789", 26)),
+		NoSegmentation: true,
+	})
+	if err != ErrShortMessageTooLong {
+		t.Fatalf("unexpected error: want %v, have %v", ErrShortMessageTooLong, err)
+	}
+	if len(pc) != 0 {
+		t.Fatalf("unexpected submit_sm: want 0, have %d", len(pc))
+	}
+}
+
+func TestSubmitLongMsgGSM7(t *testing.T) {
+	pc := make(chan pdu.Body, 10)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	a151 := strings.Repeat("a", 151)
+	test := []struct {
+		text     string
+		segments []string
+	}{
+		// Segments carry 152 septets, with a 7 octet UDH.
+		{strings.Repeat("a", 200), []string{strings.Repeat("a", 152), strings.Repeat("a", 48)}},
+		// An extension character on the 152nd septet moves to the
+		// next segment, rather than being split from its escape.
+		{a151 + "€b", []string{a151, "€b"}},
+		{a151 + "b€", []string{a151 + "b", "€"}},
+	}
+	for i, tc := range test {
+		_, err := tr.SubmitLongMsg(&ShortMessage{
+			Src:  "root",
+			Dst:  "foobar",
+			Text: pdutext.GSM7(tc.text),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, text := range tc.segments {
+			select {
+			case p := <-pc:
+				sm := p.Fields()[pdufield.ShortMessage].Bytes()
+				if want := pdutext.GSM7(text).Encode(); len(sm) < 7 || !bytes.Equal(sm[7:], want) {
+					t.Fatalf("unexpected segment %d of test %d: want %q, have %q", j, i, want, sm)
+				}
+			default:
+				t.Fatalf("missing segment %d of test %d", j, i)
+			}
+		}
+		if len(pc) != 0 {
+			t.Fatalf("unexpected number of segments for test %d: want %d, have %d", i, len(tc.segments), len(tc.segments)+len(pc))
+		}
+	}
+}
+
+func TestSubmitLongMsgGSM7Extension(t *testing.T) {
+	pc := make(chan pdu.Body, 10)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	// Extension characters after an odd offset put an escape on
+	// every segment boundary.
+	text := "a" + strings.Repeat("€[]{}", 60)
+	_, err := tr.SubmitLongMsg(&ShortMessage{
+		Src:  "root",
+		Dst:  "foobar",
+		Text: pdutext.GSM7(text),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged []byte
+	for len(pc) > 0 {
+		p := <-pc
+		sm := p.Fields()[pdufield.ShortMessage].Bytes()[7:]
+		if len(sm) > 152 {
+			t.Fatalf("unexpected segment length: want 152 or less, have %d", len(sm))
+		}
+		// Each segment decodes on its own, as on the handset.
+		merged = append(merged, pdutext.GSM7(sm).Decode()...)
+	}
+	if string(merged) != text {
+		t.Fatalf("unexpected text: want %q, have %q", text, merged)
+	}
+}
+
+func TestSubmitInternationalAddr(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	test := []struct {
+		ton     uint8
+		raw     bool
+		wantSrc string
+		wantDst string
+	}{
+		{ton: 1, wantSrc: "15551234567", wantDst: "447700900123"},
+		{ton: 1, raw: true, wantSrc: "+15551234567", wantDst: "+447700900123"},
+		{ton: 0, wantSrc: "+15551234567", wantDst: "+447700900123"},
+	}
+	for _, tc := range test {
+		_, err := tr.Submit(&ShortMessage{
+			Src:           "+15551234567",
+			Dst:           "+447700900123",
+			SourceAddrTON: tc.ton,
+			DestAddrTON:   tc.ton,
+			RawAddr:       tc.raw,
+			Text:          pdutext.Raw("Lorem ipsum"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := (<-pc).Fields()
+		if src := f[pdufield.SourceAddr].String(); src != tc.wantSrc {
+			t.Fatalf("unexpected source_addr: want %q, have %q", tc.wantSrc, src)
+		}
+		if dst := f[pdufield.DestinationAddr].String(); dst != tc.wantDst {
+			t.Fatalf("unexpected destination_addr: want %q, have %q", tc.wantDst, dst)
+		}
+	}
+}
+
+func TestBindAlreadyBound(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for n := 0; ; n++ {
+			fd, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c := &conn{rwc: fd, r: bufio.NewReader(fd), w: bufio.NewWriter(fd)}
+			p, err := c.Read()
+			if err != nil {
+				fd.Close()
+				continue
+			}
+			r := pdu.NewBindTransmitterResp()
+			r.Header().Seq = p.Header().Seq
+			if n == 0 {
+				// Previous session still alive on the first attempt.
+				r.Header().Status = statusAlreadyBound
+				c.Write(r)
+				fd.Close()
+				continue
+			}
+			r.Fields().Set(pdufield.SystemID, smpptest.DefaultSystemID)
+			c.Write(r)
+			defer fd.Close()
+		}
+	}()
+	tx := &Transmitter{
+		Addr:                 l.Addr().String(),
+		User:                 smpptest.DefaultUser,
+		Passwd:               smpptest.DefaultPasswd,
+		AlreadyBoundInterval: 100 * time.Millisecond,
+	}
+	defer tx.Close()
+	status := tx.Bind()
+	conn := <-status
+	if conn.Status() != BindFailed {
+		t.Fatalf("unexpected status: want BindFailed, have %s", conn.Status())
+	}
+	if conn.Error() != ErrAlreadyBound {
+		t.Fatalf("unexpected error: want ErrAlreadyBound, have %v", conn.Error())
+	}
+	select {
+	case conn = <-status:
+		if conn.Status() != Connected {
+			t.Fatalf("unexpected status: want Connected, have %s: %v",
+				conn.Status(), conn.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for bind retry")
+	}
+}
+
+func TestMaxBinds(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var open, max int32
+	go func() {
+		for {
+			fd, err := l.Accept()
+			if err != nil {
+				return
+			}
+			n := atomic.AddInt32(&open, 1)
+			for m := atomic.LoadInt32(&max); n > m; m = atomic.LoadInt32(&max) {
+				if atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			go func() {
+				defer atomic.AddInt32(&open, -1)
+				defer fd.Close()
+				c := &conn{rwc: fd, r: bufio.NewReader(fd), w: bufio.NewWriter(fd)}
+				p, err := c.Read()
+				if err != nil {
+					return
+				}
+				r := pdu.NewBindTransmitterResp()
+				r.Header().Seq = p.Header().Seq
+				r.Fields().Set(pdufield.SystemID, smpptest.DefaultSystemID)
+				c.Write(r)
+				for {
+					if _, err := c.Read(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	const maxBinds = 2
+	limit := NewBindLimit(maxBinds)
+	var connected int32
+	for i := 0; i < 5; i++ {
+		tr := &Transmitter{
+			Addr:         l.Addr().String(),
+			User:         smpptest.DefaultUser,
+			Passwd:       smpptest.DefaultPasswd,
+			BindInterval: 10 * time.Millisecond,
+			BindLimit:    limit,
+		}
+		defer tr.Close()
+		go func(status <-chan ConnStatus) {
+			for st := range status {
+				switch st.Status() {
+				case Connected:
+					atomic.AddInt32(&connected, 1)
+				case ConnectionFailed:
+					if st.Error() != ErrMaxBinds {
+						t.Errorf("unexpected error: want ErrMaxBinds, have %v", st.Error())
+					}
+				}
+			}
+		}(tr.Bind())
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := atomic.LoadInt32(&max); n != maxBinds {
+		t.Fatalf("unexpected max sessions: want %d, have %d", maxBinds, n)
+	}
+	if n := atomic.LoadInt32(&connected); n != maxBinds {
+		t.Fatalf("unexpected connected clients: want %d, have %d", maxBinds, n)
+	}
+}
+
+func TestBindLimitShared(t *testing.T) {
+	s := smpptest.NewServer()
+	defer s.Close()
+	// Clients of different limits do not count against each other,
+	// even to the same Addr with the same User.
+	for i := 0; i < 2; i++ {
+		tr := &Transmitter{
+			Addr:      s.Addr(),
+			User:      smpptest.DefaultUser,
+			Passwd:    smpptest.DefaultPasswd,
+			BindLimit: NewBindLimit(1),
+		}
+		defer tr.Close()
+		if st := <-tr.Bind(); st.Status() != Connected {
+			t.Fatalf("unexpected status of client %d: want Connected, have %s (%v)", i, st.Status(), st.Error())
+		}
+	}
+}
+
+type congestionLimiter struct {
+	state chan uint8
+}
+
+func (l *congestionLimiter) Wait(ctx context.Context) error { return nil }
+
+func (l *congestionLimiter) SetCongestionState(state uint8) {
+	select {
+	case l.state <- state:
+	default:
+	}
+}
+
+func TestCongestionState(t *testing.T) {
+	defer func(d time.Duration) { minEnquireLink = d }(minEnquireLink)
+	minEnquireLink = 10 * time.Millisecond
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.EnquireLinkID:
+			r := pdu.NewEnquireLinkRespSeq(p.Header().Seq)
+			r.TLVFields().Set(pdufield.CongestionState, uint8(80))
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	lm := &congestionLimiter{state: make(chan uint8, 1)}
+	tr := &Transmitter{
+		Addr:        s.Addr(),
+		User:        smpptest.DefaultUser,
+		Passwd:      smpptest.DefaultPasswd,
+		EnquireLink: 20 * time.Millisecond,
+		RateLimiter: lm,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	select {
+	case state := <-lm.state:
+		if state != 80 {
+			t.Fatalf("unexpected limiter congestion state: want 80, have %d", state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for congestion state")
+	}
+	if state, ok := tr.CongestionState(); !ok || state != 80 {
+		t.Fatalf("unexpected congestion state: want 80, have %d (%t)", state, ok)
+	}
+}
+
+func TestPing(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.EnquireLinkID:
+			time.Sleep(delay)
+			c.Write(pdu.NewEnquireLinkRespSeq(p.Header().Seq))
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rtt, err := tr.Ping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt < delay || rtt > time.Second {
+		t.Fatalf("unexpected rtt: want %s or more, have %s", delay, rtt)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), delay/2)
+	defer cancel()
+	if _, err := tr.Ping(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: want context.DeadlineExceeded, have %v", err)
+	}
+}
+
+func TestPendingRequests(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		if p.Header().ID != pdu.SubmitSMID { // Never answer submit_sm.
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:        s.Addr(),
+		User:        smpptest.DefaultUser,
+		Passwd:      smpptest.DefaultPasswd,
+		RespTimeout: time.Second,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	sm := &ShortMessage{
+		Src:       "root",
+		Dst:       "foobar",
+		Text:      pdutext.Raw("Lorem ipsum"),
+		TLVFields: make(pdufield.TLVMap),
+	}
+	sm.TLVFields.SetUserMessageReference(42)
+	errc := make(chan error)
+	go func() {
+		_, err := tr.Submit(sm)
+		errc <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	list := tr.PendingRequests()
+	if len(list) != 1 {
+		t.Fatalf("unexpected pending requests: want 1, have %#v", list)
+	}
+	pi := list[0]
+	if pi.ID != pdu.SubmitSMID || pi.Dst != "foobar" || !pi.HasUserRef || pi.UserRef != 42 {
+		t.Fatalf("unexpected pending request: %#v", pi)
+	}
+	if pi.Age < 100*time.Millisecond || pi.Age > time.Second {
+		t.Fatalf("unexpected age: want 100ms or more, have %s", pi.Age)
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("unexpected response for submit_sm")
+	}
+	if list := tr.PendingRequests(); len(list) != 0 {
+		t.Fatalf("unexpected pending requests after timeout: %#v", list)
+	}
+}
+
+// stuckConn is a net.Conn whose writes block until it is closed,
+// once stuck is set.
+type stuckConn struct {
+	net.Conn
+	stuck  *int32
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *stuckConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(c.stuck) == 1 {
+		<-c.closed
+		return 0, errors.New("use of closed connection")
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *stuckConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestWriteTimeout(t *testing.T) {
+	var stuck int32
+	defer func(f func(d *net.Dialer, network, addr string) (net.Conn, error)) { dialConn = f }(dialConn)
+	dialConn = func(d *net.Dialer, network, addr string) (net.Conn, error) {
+		fd, err := d.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &stuckConn{Conn: fd, stuck: &stuck, closed: make(chan struct{})}, nil
+	}
+	waiting := make(chan struct{})
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			if p.Fields()[pdufield.ShortMessage].String() == "wait" {
+				// Leave it waiting on its response.
+				close(waiting)
+				return
+			}
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:         s.Addr(),
+		User:         smpptest.DefaultUser,
+		Passwd:       smpptest.DefaultPasswd,
+		WriteTimeout: 100 * time.Millisecond,
+		RespTimeout:  5 * time.Second,
+		BindInterval: 10 * time.Millisecond,
+	}
+	defer tr.Close()
+	status := tr.Bind()
+	conn := <-status
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	waitc := make(chan error, 1)
+	go func() {
+		_, err := tr.Submit(&ShortMessage{
+			Src:  "root",
+			Dst:  "foobar",
+			Text: pdutext.Raw("wait"),
+		})
+		waitc <- err
+	}()
+	select {
+	case <-waiting:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for submit_sm")
+	}
+	atomic.StoreInt32(&stuck, 1)
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := tr.Submit(&ShortMessage{
+				Src:  "root",
+				Dst:  "foobar",
+				Text: pdutext.Raw("Lorem ipsum"),
+			})
+			errc <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Fatal("unexpected submit through stuck connection")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("submit still blocked on stuck connection")
+		}
+	}
+	select {
+	case err := <-waitc:
+		if err != ErrWriteTimeout {
+			t.Fatalf("unexpected error of waiting submit: want %v, have %v", ErrWriteTimeout, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("submit still waiting for response from stuck connection")
+	}
+	select {
+	case conn = <-status:
+		if conn.Status() != Disconnected {
+			t.Fatalf("unexpected status: want Disconnected, have %s", conn.Status())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for disconnection")
+	}
+	atomic.StoreInt32(&stuck, 0)
+	select {
+	case conn = <-status:
+		if conn.Status() != Connected {
+			t.Fatalf("unexpected status: want Connected, have %s: %v", conn.Status(), conn.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for reconnection")
+	}
+}
+
+// panicCodec is a text codec that must not be used.
+type panicCodec struct{}
+
+func (panicCodec) Type() pdutext.DataCoding { panic("unexpected codec Type") }
+func (panicCodec) Encode() []byte           { panic("unexpected codec Encode") }
+func (panicCodec) Decode() []byte           { panic("unexpected codec Decode") }
+
+func TestSubmitEncoded(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	want := []byte{0x00, 0x48, 0x00, 0x69, 0xD8, 0x3D, 0xDE, 0x00}
+	_, err := tr.Submit(&ShortMessage{
+		Src:        "root",
+		Dst:        "foobar",
+		Text:       panicCodec{},
+		Encoded:    want,
+		DataCoding: 0xF6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := (<-pc).Fields()
+	if have := f[pdufield.ShortMessage].Bytes(); !bytes.Equal(have, want) {
+		t.Fatalf("unexpected short_message: want %#x, have %#x", want, have)
+	}
+	if dc := f[pdufield.DataCoding].Bytes(); !bytes.Equal(dc, []byte{0xF6}) {
+		t.Fatalf("unexpected data_coding: want 0xf6, have %#x", dc)
+	}
+	if n := f[pdufield.SMLength].Bytes(); !bytes.Equal(n, []byte{uint8(len(want))}) {
+		t.Fatalf("unexpected sm_length: want %d, have %d", len(want), n)
+	}
+}
+
+func TestAnyPDUKeepAlive(t *testing.T) {
+	defer func(d time.Duration) { minEnquireLink = d }(minEnquireLink)
+	minEnquireLink = 10 * time.Millisecond
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		}
+		// EnquireLink is never answered.
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:               s.Addr(),
+		User:               smpptest.DefaultUser,
+		Passwd:             smpptest.DefaultPasswd,
+		EnquireLink:        20 * time.Millisecond,
+		EnquireLinkTimeout: 60 * time.Millisecond,
+		AnyPDUKeepAlive:    true,
+		BindInterval:       time.Second,
+	}
+	defer tr.Close()
+	status := tr.Bind()
+	conn := <-status
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	for i := 0; i < 30; i++ {
+		_, err := tr.Submit(&ShortMessage{
+			Src:      "root",
+			Dst:      "foobar",
+			Text:     pdutext.Raw("Lorem ipsum"),
+			Register: pdufield.NoDeliveryReceipt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case conn = <-status:
+		t.Fatalf("unexpected status while submitting: %s", conn.Status())
+	default:
+	}
+	// Without traffic the link is still considered dead.
+	select {
+	case conn = <-status:
+		if conn.Status() != Disconnected {
+			t.Fatalf("unexpected status: want Disconnected, have %s", conn.Status())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle link without enquire_link_resp was not disconnected")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "smsc.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	s := smpptest.NewUnstartedServerListener(l)
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:    path,
+		Network: "unix",
+		User:    smpptest.DefaultUser,
+		Passwd:  smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	sm, err := tr.Submit(&ShortMessage{
+		Src:  "root",
+		Dst:  "foobar",
+		Text: pdutext.Raw("Lorem ipsum"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := sm.RespID(); id != "foobar" {
+		t.Fatalf("unexpected message id: want foobar, have %q", id)
+	}
+}
+
+func TestDefaultTLVs(t *testing.T) {
+	const tenantID = pdufield.TLVTag(0x1401)
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	defaults := pdufield.TLVMap{}
+	defaults.Set(tenantID, "tenant-a")
+	tr := &Transmitter{
+		Addr:        s.Addr(),
+		User:        smpptest.DefaultUser,
+		Passwd:      smpptest.DefaultPasswd,
+		DefaultTLVs: defaults,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	override := pdufield.TLVMap{}
+	override.Set(tenantID, "tenant-b")
+	test := []struct {
+		tlv  pdufield.TLVMap
+		want string
+	}{
+		{want: "tenant-a"},
+		{tlv: override, want: "tenant-b"},
+	}
+	for _, tc := range test {
+		_, err := tr.Submit(&ShortMessage{
+			Src:       "root",
+			Dst:       "foobar",
+			Text:      pdutext.Raw("Lorem ipsum"),
+			TLVFields: tc.tlv,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlv, ok := (<-pc).TLVFields()[tenantID]
+		if !ok {
+			t.Fatal("missing tenant id TLV")
+		}
+		if have := string(tlv.Bytes()); have != tc.want {
+			t.Fatalf("unexpected tenant id: want %q, have %q", tc.want, have)
+		}
+	}
+	if v := string(defaults[tenantID].Bytes()); v != "tenant-a" {
+		t.Fatalf("unexpected change of DefaultTLVs: %q", v)
+	}
+}
+
+func TestOnBeforeSend(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	var seqs []uint32
+	var tr *Transmitter
+	tr = &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+		OnBeforeSend: func(seq uint32, p pdu.Body) {
+			if len(pc) > 0 {
+				t.Error("unexpected call after PDU was sent")
+			}
+			// The hook may call back into the Transmitter.
+			if pr := tr.PendingRequests(); len(pr) != 1 || pr[0].Seq != seq {
+				t.Errorf("unexpected pending requests: want seq %d, have %+v", seq, pr)
+			}
+			if p.Header().ID != pdu.SubmitSMID {
+				t.Errorf("unexpected PDU: want SubmitSM, have %s", p.Header().ID)
+			}
+			seqs = append(seqs, seq)
+		},
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	for i := 0; i < 3; i++ {
+		sm, err := tr.Submit(&ShortMessage{
+			Src:  "root",
+			Dst:  "foobar",
+			Text: pdutext.Raw("Lorem ipsum"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := <-pc
+		if len(seqs) != i+1 {
+			t.Fatalf("unexpected number of calls: want %d, have %d", i+1, len(seqs))
+		}
+		if want, have := p.Header().Seq, seqs[i]; want != have {
+			t.Fatalf("unexpected seq: want %d, have %d", want, have)
+		}
+		if want, have := sm.Resp().Header().Seq, seqs[i]; want != have {
+			t.Fatalf("unexpected resp seq: want %d, have %d", want, have)
+		}
+	}
+}
+
+func TestParseRespDetails(t *testing.T) {
+	p := pdu.NewDataSMResp()
+	p.Fields().Set(pdufield.MessageID, "foobar")
+	tlv := p.TLVFields()
+	tlv.Set(pdufield.MessageStateOption, uint8(pdufield.StateUndeliverable))
+	tlv.Set(pdufield.DeliveryFailureReason, uint8(2))
+	tlv.Set(pdufield.NetworkErrorCode, []byte{0x03, 0x00, 0x22})
+	tlv.Set(pdufield.AdditionalStatusInfoText, "absent subscriber\x00")
+	var b bytes.Buffer
+	if err := p.SerializeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	p, err := pdu.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := ParseRespDetails(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rd.MessageID != "foobar" {
+		t.Fatalf("unexpected message id: want foobar, have %q", rd.MessageID)
+	}
+	if rd.State == nil || *rd.State != pdufield.StateUndeliverable {
+		t.Fatalf("unexpected state: want %s, have %v", pdufield.StateUndeliverable, rd.State)
+	}
+	if rd.DeliveryFailureReason == nil || *rd.DeliveryFailureReason != 2 {
+		t.Fatalf("unexpected delivery failure reason: %v", rd.DeliveryFailureReason)
+	}
+	if ne := rd.NetworkError; ne == nil || ne.Type != 3 || ne.Code != 0x22 {
+		t.Fatalf("unexpected network error: %#v", ne)
+	}
+	if rd.DpfResult != nil {
+		t.Fatalf("unexpected dpf result: %v", *rd.DpfResult)
+	}
+	if rd.AdditionalStatusInfo != "absent subscriber" {
+		t.Fatalf("unexpected status info: want %q, have %q", "absent subscriber", rd.AdditionalStatusInfo)
+	}
+	// submit_sm_resp is parsed alike.
+	p = pdu.NewSubmitSMResp()
+	p.Fields().Set(pdufield.MessageID, "foobar")
+	rd, err = ParseRespDetails(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rd.MessageID != "foobar" || rd.State != nil {
+		t.Fatalf("unexpected details: %#v", rd)
+	}
+	if _, err = ParseRespDetails(pdu.NewDeliverSM()); err == nil {
+		t.Fatal("unexpected details of deliver_sm")
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no IPv4 loopback: %v", err)
+	}
+	local := l.Addr().(*net.TCPAddr)
+	l.Close()
+	rc := make(chan net.Addr, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			rc <- c.RemoteAddr()
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:      s.Addr(),
+		User:      smpptest.DefaultUser,
+		Passwd:    smpptest.DefaultPasswd,
+		LocalAddr: local,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	_, err = tr.Submit(&ShortMessage{
+		Src:  "root",
+		Dst:  "foobar",
+		Text: pdutext.Raw("Lorem ipsum"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := (<-rc).String(); have != local.String() {
+		t.Fatalf("unexpected remote address: want %s, have %s", local, have)
+	}
+}
+
+// failConn is a net.Conn whose first write after fail is set to 1
+// fails, closing the connection, or stalls if set to 2.
+type failConn struct {
+	net.Conn
+	fail *int32
+}
+
+func (c *failConn) Write(b []byte) (int, error) {
+	if atomic.CompareAndSwapInt32(c.fail, 1, 0) {
+		c.Conn.Close()
+		return 0, &net.OpError{Op: "write", Net: "tcp", Err: errors.New("broken pipe")}
+	}
+	if atomic.CompareAndSwapInt32(c.fail, 2, 0) {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestRetryOnReconnect(t *testing.T) {
+	var fail, dials int32
+	defer func(f func(d *net.Dialer, network, addr string) (net.Conn, error)) { dialConn = f }(dialConn)
+	dialConn = func(d *net.Dialer, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		fd, err := d.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &failConn{Conn: fd, fail: &fail}, nil
+	}
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	submit := func(tr *Transmitter) error {
+		_, err := tr.Submit(&ShortMessage{
+			Src:  "root",
+			Dst:  "foobar",
+			Text: pdutext.Raw("Lorem ipsum"),
+		})
+		return err
+	}
+	for _, retry := range []time.Duration{0, time.Second} {
+		tr := &Transmitter{
+			Addr:             s.Addr(),
+			User:             smpptest.DefaultUser,
+			Passwd:           smpptest.DefaultPasswd,
+			BindInterval:     50 * time.Millisecond,
+			RetryOnReconnect: retry,
+		}
+		conn := <-tr.Bind()
+		switch conn.Status() {
+		case Connected:
+		default:
+			t.Fatal(conn.Error())
+		}
+		atomic.StoreInt32(&dials, 0)
+		atomic.StoreInt32(&fail, 1)
+		err := submit(tr)
+		switch {
+		case retry == 0 && err == nil:
+			t.Fatal("unexpected submit without RetryOnReconnect")
+		case retry > 0 && err != nil:
+			t.Fatalf("unexpected error with RetryOnReconnect: %v", err)
+		case retry > 0 && atomic.LoadInt32(&dials) != 1:
+			t.Fatalf("unexpected number of reconnects: want 1, have %d", atomic.LoadInt32(&dials))
+		}
+		tr.Close()
+	}
+	// The SMSC may still get a PDU whose write timed out, no retry.
+	tr := &Transmitter{
+		Addr:             s.Addr(),
+		User:             smpptest.DefaultUser,
+		Passwd:           smpptest.DefaultPasswd,
+		BindInterval:     50 * time.Millisecond,
+		WriteTimeout:     50 * time.Millisecond,
+		RetryOnReconnect: time.Second,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	atomic.StoreInt32(&fail, 2)
+	if err := submit(tr); err != ErrWriteTimeout {
+		t.Fatalf("unexpected error: want ErrWriteTimeout, have %v", err)
+	}
+}
+
+func TestSubmitCannedMessage(t *testing.T) {
+	pc := make(chan pdu.Body, 1)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	if _, err := tr.Submit(&ShortMessage{Src: "root", Dst: "foobar"}); err != ErrNoText {
+		t.Fatalf("unexpected error: want ErrNoText, have %v", err)
+	}
+	for _, submit := range []func(*ShortMessage) (*ShortMessage, error){tr.Submit, tr.SubmitLongMsg} {
+		sm, err := submit(&ShortMessage{
+			Src:            "root",
+			Dst:            "foobar",
+			SMDefaultMsgID: 5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := sm.RespID(); id != "foobar" {
+			t.Fatalf("unexpected message id: want foobar, have %q", id)
+		}
+		p := <-pc
+		f := p.Fields()
+		if n := f[pdufield.SMLength].Raw().(uint8); n != 0 {
+			t.Fatalf("unexpected sm_length: want 0, have %d", n)
+		}
+		if esm := f[pdufield.ESMClass].Raw().(uint8); esm != 0 {
+			t.Fatalf("unexpected esm_class: want 0, have %#x", esm)
+		}
+		// sm_default_msg_id and sm_length close the PDU, with no
+		// short_message.
+		raw := pdu.Raw(p)
+		if have := raw[len(raw)-2:]; !bytes.Equal(have, []byte{0x05, 0x00}) {
+			t.Fatalf("unexpected framing: want 0x0500, have %#x", have)
+		}
+	}
+}
+
+func TestSwappedResp(t *testing.T) {
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			r := pdu.NewSubmitSMResp()
+			r.Header().ID = pdu.SubmitSMID // Buggy SMSC.
+			r.Header().Seq = p.Header().Seq
+			if p.Fields()[pdufield.DestinationAddr].String() == "throttled" {
+				r.Header().Status = 0x58
+			}
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	tr := &Transmitter{
+		Addr:   s.Addr(),
+		User:   smpptest.DefaultUser,
+		Passwd: smpptest.DefaultPasswd,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	n := SwappedResps()
+	sm, err := tr.Submit(&ShortMessage{
+		Src:  "root",
+		Dst:  "foobar",
+		Text: pdutext.Raw("Lorem ipsum"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := sm.Resp().Header().ID; id != pdu.SubmitSMRespID {
+		t.Fatalf("unexpected resp: want SubmitSMResp, have %s", id)
+	}
+	if id := sm.RespID(); id != "foobar" {
+		t.Fatalf("unexpected message_id: want foobar, have %q", id)
+	}
+	_, err = tr.Submit(&ShortMessage{
+		Src:  "root",
+		Dst:  "throttled",
+		Text: pdutext.Raw("Lorem ipsum"),
+	})
+	if err != pdu.Status(0x58) {
+		t.Fatalf("unexpected error: want ESME_RTHROTTLED, have %v", err)
+	}
+	if have := SwappedResps() - n; have != 2 {
+		t.Fatalf("unexpected number of swapped resps: want 2, have %d", have)
+	}
+}
+
+// gateLimiter is a RateLimiter that holds the first write until gate
+// is closed.
+type gateLimiter struct {
+	once    sync.Once
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func (l *gateLimiter) Wait(ctx context.Context) error {
+	first := false
+	l.once.Do(func() { first = true })
+	if first {
+		close(l.entered)
+		<-l.gate
+	}
+	return nil
+}
+
+func TestOrderByDst(t *testing.T) {
+	pc := make(chan pdu.Body, 10)
+	s := smpptest.NewUnstartedServer()
+	s.Handler = func(c smpptest.Conn, p pdu.Body) {
+		switch p.Header().ID {
+		case pdu.SubmitSMID:
+			pc <- p
+			r := pdu.NewSubmitSMResp()
+			r.Header().Seq = p.Header().Seq
+			r.Fields().Set(pdufield.MessageID, "foobar")
+			c.Write(r)
+		default:
+			smpptest.EchoHandler(c, p)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	lm := &gateLimiter{entered: make(chan struct{}), gate: make(chan struct{})}
+	tr := &Transmitter{
+		Addr:        s.Addr(),
+		User:        smpptest.DefaultUser,
+		Passwd:      smpptest.DefaultPasswd,
+		RespTimeout: 2 * time.Second,
+		RateLimiter: lm,
+		OrderByDst:  true,
+	}
+	defer tr.Close()
+	conn := <-tr.Bind()
+	switch conn.Status() {
+	case Connected:
+	default:
+		t.Fatal(conn.Error())
+	}
+	const n = 5
+	errc := make(chan error, n+1)
+	submit := func(dst, text string) {
+		_, err := tr.Submit(&ShortMessage{
+			Src:  "root",
+			Dst:  dst,
+			Text: pdutext.Raw(text),
+		})
+		errc <- err
+	}
+	// Queue the submits to the same destination one by one, the
+	// first one is held by the rate limiter.
+	go submit("5551234", "0")
+	<-lm.entered
+	for i := 1; i < n; i++ {
+		tr.dst.Lock()
+		prev := tr.dst.tail["5551234"]
+		tr.dst.Unlock()
+		go submit("5551234", strconv.Itoa(i))
+		deadline := time.Now().Add(time.Second)
+		for queued := false; !queued; {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for submit to queue")
+			}
+			time.Sleep(time.Millisecond)
+			tr.dst.Lock()
+			queued = tr.dst.tail["5551234"] != prev
+			tr.dst.Unlock()
+		}
+	}
+	// Other destinations are not held.
+	go submit("5559999", "other")
+	select {
+	case p := <-pc:
+		if dst := p.Fields()[pdufield.DestinationAddr].String(); dst != "5559999" {
+			t.Fatalf("unexpected destination: want 5559999, have %s", dst)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("submit to other destination held")
+	}
+	close(lm.gate)
+	for i := 0; i < n; i++ {
+		select {
+		case p := <-pc:
+			if text := p.Fields()[pdufield.ShortMessage].String(); text != strconv.Itoa(i) {
+				t.Fatalf("unexpected order: want %d, have %s", i, text)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for submit_sm")
+		}
+	}
+	for i := 0; i <= n; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}